@@ -41,8 +41,8 @@ func (c *createAdminCommand) Run(db *sql.DB) error {
 	m := models.New(db)
 
 	user := &models.User{
-		Name:  c.name,
-		Level: models.ADMIN_LEVEL,
+		Name: c.name,
+		Role: models.RoleAdmin,
 	}
 
 	user.Password.Set(c.password)
@@ -6,14 +6,26 @@ import (
 	"flag"
 	"fmt"
 	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
 	"time"
 )
 
 type createEventCommand struct {
-	name      string
-	dayString string
-	day       *time.Time
-	fs        *flag.FlagSet
+	name            string
+	description     string
+	dayString       string
+	day             *time.Time
+	endDayString    string
+	endDay          *time.Time
+	location        string
+	latitudeString  string
+	latitude        *float32
+	longitudeString string
+	longitude       *float32
+	parent          int
+	category        string
+	fs              *flag.FlagSet
 }
 
 func (c *createEventCommand) Init(args []string) error {
@@ -40,6 +52,37 @@ func (c *createEventCommand) Init(args []string) error {
 		c.day = &day
 	}
 
+	if c.endDayString != "" {
+		var endDay time.Time
+
+		endDay, err = time.Parse(time.DateOnly, c.endDayString)
+		if err != nil {
+			return err
+		}
+
+		c.endDay = &endDay
+	}
+
+	if c.latitudeString != "" {
+		latitude, err := strconv.ParseFloat(c.latitudeString, 32)
+		if err != nil {
+			return err
+		}
+
+		lat := float32(latitude)
+		c.latitude = &lat
+	}
+
+	if c.longitudeString != "" {
+		longitude, err := strconv.ParseFloat(c.longitudeString, 32)
+		if err != nil {
+			return err
+		}
+
+		lon := float32(longitude)
+		c.longitude = &lon
+	}
+
 	return nil
 }
 
@@ -50,8 +93,27 @@ func (c *createEventCommand) Run(db *sql.DB) error {
 	m := models.New(db)
 
 	event := &models.Event{
-		Name: c.name,
-		Date: c.day,
+		Name:        c.name,
+		Description: c.description,
+		Date:        c.day,
+		EndDate:     c.endDay,
+		Location:    c.location,
+		Latitude:    c.latitude,
+		Longitude:   c.longitude,
+		Category:    c.category,
+	}
+
+	if c.parent != 0 {
+		event.Parent = &c.parent
+	}
+
+	v := validator.Validator{}
+	if models.ValidateEvent(&v, event); !v.Valid() {
+		for key, msg := range v.FieldErrors {
+			fmt.Printf("%s: %s\n", key, msg)
+		}
+
+		return errors.New("Invalid event")
 	}
 
 	err := m.Events.Insert(event)
@@ -71,7 +133,14 @@ func newCreateEventCommand() *createEventCommand {
 		fs: flag.NewFlagSet("createEvent", flag.ContinueOnError),
 	}
 	c.fs.StringVar(&c.name, "event", "", "Event name")
-	c.fs.StringVar(&c.dayString, "day", "", "Event date YY-MM-DD")
+	c.fs.StringVar(&c.description, "description", "", "Event description")
+	c.fs.StringVar(&c.dayString, "day", "", "Event (start) date YYYY-MM-DD")
+	c.fs.StringVar(&c.endDayString, "end-day", "", "Event end date YYYY-MM-DD")
+	c.fs.StringVar(&c.location, "location", "", "Location name")
+	c.fs.StringVar(&c.latitudeString, "latitude", "", "Location latitude")
+	c.fs.StringVar(&c.longitudeString, "longitude", "", "Location longitude")
+	c.fs.IntVar(&c.parent, "parent", 0, "Parent event id")
+	c.fs.StringVar(&c.category, "category", "", "Category, for top level events")
 
 	return c
 }
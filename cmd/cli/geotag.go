@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/geotag"
+	"time"
+)
+
+type geotagCommand struct {
+	event     int
+	trackPath string
+	track     geotag.Track
+	offset    int
+	maxGap    int
+	overwrite bool
+	dryRun    bool
+	fs        *flag.FlagSet
+}
+
+func (c *geotagCommand) Init(args []string) error {
+	err := c.fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if c.event == 0 || c.trackPath == "" {
+		c.fs.Usage()
+		fmt.Println()
+
+		return errors.New("Not enough arguments provided")
+	}
+
+	if c.maxGap <= 0 {
+		return errors.New("max-gap must be greater than zero")
+	}
+
+	f, err := os.Open(c.trackPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.track, err = geotag.Parse(c.trackPath, f)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *geotagCommand) Run(db *sql.DB) error {
+	m := models.New(db)
+
+	event, err := m.Events.GetByID(c.event)
+	if err != nil {
+		return err
+	}
+
+	photos, err := m.Photos.GetAll(&event.ID)
+	if err != nil {
+		return err
+	}
+
+	offset := time.Duration(c.offset) * time.Minute
+	maxGap := time.Duration(c.maxGap) * time.Minute
+
+	located := []*models.Photo{}
+	for _, p := range photos {
+		if p.TakenAt == nil || (p.Latitude != nil && !c.overwrite) {
+			continue
+		}
+
+		point, ok := c.track.Locate(p.TakenAt.Add(offset), maxGap)
+		if !ok {
+			continue
+		}
+
+		lat, lon := float32(point.Latitude), float32(point.Longitude)
+		p.Latitude, p.Longitude = &lat, &lon
+		located = append(located, p)
+
+		fmt.Printf("%s\t%s\t%f, %f\n", p.FileName, p.TakenAt.Format(time.DateTime), lat, lon)
+	}
+
+	fmt.Printf("%d of %d photos matched the track\n", len(located), len(photos))
+
+	if c.dryRun || len(located) == 0 {
+		return nil
+	}
+
+	return m.Photos.SetLocations(located)
+}
+
+func (c *geotagCommand) Name() string {
+	return "geotag"
+}
+
+func newGeotagCommand() *geotagCommand {
+	c := &geotagCommand{
+		fs: flag.NewFlagSet("geotag", flag.ContinueOnError),
+	}
+	c.fs.IntVar(&c.event, "event", 0, "Event id")
+	c.fs.StringVar(&c.trackPath, "track", "", "GPX or KML track file")
+	c.fs.IntVar(&c.offset, "offset", 0, "Minutes to add to the photos' time to match the track's time")
+	c.fs.IntVar(&c.maxGap, "max-gap", 10, "Maximum minutes between track points to interpolate")
+	c.fs.BoolVar(&c.overwrite, "overwrite", false, "Overwrite existing locations")
+	c.fs.BoolVar(&c.dryRun, "dry-run", false, "Only show the proposed locations, without saving them")
+
+	return c
+}
@@ -36,6 +36,9 @@ func main() {
 		newInsertPhotosCommand(),
 		newCreateAdminCommand(),
 		newEventFoldersIDCommand(),
+		newGeotagCommand(),
+		newCreateTokenCommand(),
+		newRevokeTokenCommand(),
 	}
 
 	// Find command, and its index in arguments list
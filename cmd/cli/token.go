@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"slices"
+	"strings"
+	"time"
+)
+
+type createTokenCommand struct {
+	user   string
+	name   string
+	scopes string
+	expiry time.Duration
+	fs     *flag.FlagSet
+}
+
+func (c *createTokenCommand) Init(args []string) error {
+	err := c.fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if c.user == "" || c.name == "" {
+		c.fs.Usage()
+		fmt.Println()
+
+		return errors.New("Not enough arguments provided")
+	}
+
+	if c.expiry < 0 {
+		return errors.New("expiry must not be negative")
+	}
+
+	return nil
+}
+
+func (c *createTokenCommand) Run(db *sql.DB) error {
+	m := models.New(db)
+
+	user, err := m.Users.GetByName(c.user)
+	if err != nil {
+		return err
+	}
+
+	var expiry *time.Time
+	if c.expiry > 0 {
+		e := time.Now().Add(c.expiry)
+		expiry = &e
+	}
+
+	token, err := models.GenerateToken(user.ID, c.name, strings.Split(c.scopes, ","), expiry)
+	if err != nil {
+		return err
+	}
+
+	v := validator.Validator{}
+	models.ValidateToken(&v, token)
+	if !v.Valid() {
+		return fmt.Errorf("invalid token: %v", v.FieldErrors)
+	}
+
+	for _, s := range token.Scopes {
+		if !slices.Contains(models.AllowedScopes(user.Role), s) {
+			return fmt.Errorf("users with the %s role cannot have %s tokens", user.Role, s)
+		}
+	}
+
+	err = m.Tokens.Insert(token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Token %d created, it will not be shown again:\n%s\n", token.ID, token.Plaintext)
+
+	return nil
+}
+
+func (c *createTokenCommand) Name() string {
+	return "createToken"
+}
+
+func newCreateTokenCommand() *createTokenCommand {
+	c := &createTokenCommand{
+		fs: flag.NewFlagSet("createToken", flag.ContinueOnError),
+	}
+	c.fs.StringVar(&c.user, "user", "", "Name of the user the token belongs to")
+	c.fs.StringVar(&c.name, "name", "", "Name of the token")
+	c.fs.StringVar(&c.scopes, "scopes", models.ScopeRead, "Comma separated scopes: read, upload, admin")
+	c.fs.DurationVar(&c.expiry, "expiry", 90*24*time.Hour, "Time after which the token expires, 0 for never")
+
+	return c
+}
+
+type revokeTokenCommand struct {
+	id int
+	fs *flag.FlagSet
+}
+
+func (c *revokeTokenCommand) Init(args []string) error {
+	err := c.fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if c.id == 0 {
+		c.fs.Usage()
+		fmt.Println()
+
+		return errors.New("No token id provided")
+	}
+
+	return nil
+}
+
+func (c *revokeTokenCommand) Run(db *sql.DB) error {
+	m := models.New(db)
+
+	return m.Tokens.Delete(c.id)
+}
+
+func (c *revokeTokenCommand) Name() string {
+	return "revokeToken"
+}
+
+func newRevokeTokenCommand() *revokeTokenCommand {
+	c := &revokeTokenCommand{
+		fs: flag.NewFlagSet("revokeToken", flag.ContinueOnError),
+	}
+	c.fs.IntVar(&c.id, "id", 0, "ID of the token")
+
+	return c
+}
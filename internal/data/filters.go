@@ -1,9 +1,12 @@
 package data
 
 import (
+	"errors"
 	"math"
 	"sitoWow/internal/validator"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Filters struct {
@@ -11,22 +14,91 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       *Cursor // If set, pages start after the cursor instead of using Page
+
+	// Photo filters, zero values mean no filtering
+	From      *time.Time // Taken at or after
+	To        *time.Time // Taken at or before
+	MediaType string     // "photo" or "video"
+	Location  string     // "with" or "without"
+	Uploader  int
+
+	// Audit log filters, From and To are reused for the time of the entries
+	Action string
+	Actor  int
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// Position of the last record of a page, for keyset pagination on (taken_at, id).
+// A zero ID means the first page
+type Cursor struct {
+	TakenAt *time.Time
+	ID      int
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursors are sent to clients as "<taken_at in RFC3339, or null>_<id>"
+func (c Cursor) String() string {
+	takenAt := "null"
+	if c.TakenAt != nil {
+		takenAt = c.TakenAt.UTC().Format(time.RFC3339)
+	}
+
+	return takenAt + "_" + strconv.Itoa(c.ID)
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return &Cursor{}, nil
+	}
+
+	takenAt, id, ok := strings.Cut(s, "_")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	var err error
+
+	c.ID, err = strconv.Atoi(id)
+	if err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	if takenAt != "null" {
+		t, err := time.Parse(time.RFC3339, takenAt)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		c.TakenAt = &t
+	}
+
+	return &c, nil
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.CheckField(f.Page > 0, "page", "must be greater than 0")
-	v.CheckField(f.Page < 10_000_000, "page", "must be smaller than 10 million")
+	// Pages are not used with cursors
+	if f.Cursor == nil {
+		v.CheckField(f.Page > 0, "page", "must be greater than 0")
+		v.CheckField(f.Page < 10_000_000, "page", "must be smaller than 10 million")
+	}
 	v.CheckField(f.PageSize > 0, "page_size", "must be greater than 0")
 	v.CheckField(f.PageSize < 100, "page_size", "must be a maximum of 100")
 	v.CheckField(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckField(f.From == nil || f.To == nil || !f.To.Before(*f.From), "to", "must not be before the start")
+	v.CheckField(validator.PermittedValue(f.MediaType, "", "photo", "video"), "type", "invalid media type")
+	v.CheckField(validator.PermittedValue(f.Location, "", "with", "without"), "location", "invalid location value")
+	v.CheckField(f.Uploader >= 0, "uploader", "invalid uploader")
+	v.CheckField(f.Actor >= 0, "actor", "invalid actor")
 }
 
 func (f Filters) SortColumn() string {
@@ -0,0 +1,367 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sitoWow/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type AlbumModelInterface interface {
+	Insert(album *Album) error
+	Update(album *Album) error
+	Delete(id int) error
+	GetByID(id int) (*Album, error)
+	GetAll() ([]*Album, error)
+	GetPhotos(album int) ([]*Photo, error)
+	AddPhotos(album int, files []string) error
+	RemovePhotos(album int, files []string) error
+	MovePhoto(album int, file string, up bool) error
+}
+
+type AlbumModel struct {
+	DB *sql.DB
+}
+
+type Album struct {
+	ID         int
+	Name       string
+	Cover      *int
+	CoverPhoto *Photo // Chosen cover, or first photo of the album if there is none
+	Count      int
+	CreatedAt  time.Time
+	Version    int
+}
+
+func ValidateAlbum(v *validator.Validator, album *Album) {
+	v.CheckField(validator.NotBlank(album.Name), "name", "This field cannot be blank")
+	v.CheckField(validator.CharsCount(album.Name, 0, 500), "name", "Name must be at most 500 characters long")
+}
+
+func (m *AlbumModel) Insert(album *Album) error {
+	query := `
+    INSERT INTO albums (name, cover)
+    VALUES ($1, $2)
+    RETURNING id, created_at, version
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, album.Name, newNullInt(album.Cover)).Scan(&album.ID, &album.CreatedAt, &album.Version)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *AlbumModel) Update(album *Album) error {
+	query := `
+    UPDATE albums
+    SET name = $1, cover = $2, version = version + 1
+    WHERE id = $3 AND version = $4
+    RETURNING version
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, album.Name, newNullInt(album.Cover), album.ID, album.Version).Scan(&album.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *AlbumModel) Delete(id int) error {
+	query := `
+    DELETE FROM albums
+    WHERE id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Albums are selected along with their cover photo: the chosen one, or the first of the album
+const albumSelect = `
+    SELECT a.id, a.name, a.cover, a.created_at, a.version,
+        (SELECT COUNT(*) FROM album_photos WHERE album_photos.album = a.id),
+        c.id, c.file_name, c.event
+    FROM albums AS a LEFT JOIN LATERAL (
+        SELECT photos.id, photos.file_name, photos.event
+        FROM photos LEFT JOIN album_photos ON album_photos.photo = photos.id AND album_photos.album = a.id
+        WHERE photos.id = a.cover OR album_photos.album = a.id
+        ORDER BY (photos.id IS NOT DISTINCT FROM a.cover) DESC, album_photos.position ASC, photos.id ASC
+        LIMIT 1
+    ) AS c ON true
+    `
+
+func scanAlbum(row interface{ Scan(...any) error }) (*Album, error) {
+	var album Album
+	var coverID, coverEvent *int
+	var coverFile *string
+
+	err := row.Scan(
+		&album.ID,
+		&album.Name,
+		&album.Cover,
+		&album.CreatedAt,
+		&album.Version,
+		&album.Count,
+		&coverID,
+		&coverFile,
+		&coverEvent,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if coverID != nil {
+		album.CoverPhoto = &Photo{
+			ID:       *coverID,
+			FileName: *coverFile,
+			Event:    *coverEvent,
+		}
+	}
+
+	return &album, nil
+}
+
+func (m *AlbumModel) GetByID(id int) (*Album, error) {
+	query := albumSelect + `WHERE a.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	album, err := scanAlbum(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return album, nil
+}
+
+// Get all albums, newest first
+func (m *AlbumModel) GetAll() ([]*Album, error) {
+	query := albumSelect + `ORDER BY a.created_at DESC, a.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []*Album{}
+
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		albums = append(albums, album)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+// Get the photos of an album, in album order
+func (m *AlbumModel) GetPhotos(album int) ([]*Photo, error) {
+	query := `
+    SELECT photos.id, file_name, created_at, taken_at, latitude, longitude, event
+    FROM album_photos JOIN photos ON album_photos.photo = photos.id
+    WHERE album_photos.album = $1
+    ORDER BY album_photos.position ASC, photos.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, album)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	photos := []*Photo{}
+
+	for rows.Next() {
+		var photo Photo
+
+		err := rows.Scan(
+			&photo.ID,
+			&photo.FileName,
+			&photo.CreatedAt,
+			&photo.TakenAt,
+			&photo.Latitude,
+			&photo.Longitude,
+			&photo.Event,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		photos = append(photos, &photo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// Append photos to the end of the album, ordered by date. Photos already in the album are ignored
+func (m *AlbumModel) AddPhotos(album int, files []string) error {
+	query := `
+    INSERT INTO album_photos (album, photo, position)
+    SELECT $1, photos.id,
+        (SELECT COALESCE(MAX(position), 0) FROM album_photos WHERE album = $1)
+            + row_number() OVER (ORDER BY taken_at ASC, photos.id ASC)
+    FROM photos
+    WHERE file_name = ANY($2)
+        AND NOT EXISTS (SELECT 1 FROM album_photos WHERE album = $1 AND photo = photos.id)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, album, pq.Array(files))
+	if err != nil {
+		if err.Error() == `pq: insert or update on table "album_photos" violates foreign key constraint "fk_album_id"` {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m *AlbumModel) RemovePhotos(album int, files []string) error {
+	query := `
+    DELETE FROM album_photos
+    USING photos
+    WHERE album_photos.photo = photos.id AND album_photos.album = $1 AND photos.file_name = ANY($2)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, album, pq.Array(files))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Swap the photo with the previous (up) or next one in the album
+func (m *AlbumModel) MovePhoto(album int, file string, up bool) error {
+	positionQuery := `
+    SELECT album_photos.photo, album_photos.position
+    FROM album_photos JOIN photos ON album_photos.photo = photos.id
+    WHERE album_photos.album = $1 AND photos.file_name = $2
+    FOR UPDATE OF album_photos
+    `
+
+	neighbourQuery := `
+    SELECT photo, position
+    FROM album_photos
+    WHERE album = $1 AND position < $2
+    ORDER BY position DESC, photo DESC
+    LIMIT 1
+    FOR UPDATE
+    `
+	if !up {
+		neighbourQuery = `
+    SELECT photo, position
+    FROM album_photos
+    WHERE album = $1 AND position > $2
+    ORDER BY position ASC, photo ASC
+    LIMIT 1
+    FOR UPDATE
+    `
+	}
+
+	updateQuery := `
+    UPDATE album_photos
+    SET position = $1
+    WHERE album = $2 AND photo = $3
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var photo, position int
+	err = tx.QueryRowContext(ctx, positionQuery, album, file).Scan(&photo, &position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	var neighbour, neighbourPosition int
+	err = tx.QueryRowContext(ctx, neighbourQuery, album, position).Scan(&neighbour, &neighbourPosition)
+	if err != nil {
+		// Already first or last, nothing to do
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, updateQuery, neighbourPosition, album, photo)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, updateQuery, position, album, neighbour)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sitoWow/internal/data"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditPhotoUpload  = "photo.upload"
+	AuditPhotoUpdate  = "photo.update"
+	AuditPhotoDelete  = "photo.delete"
+	AuditPhotoRestore = "photo.restore"
+	AuditEventCreate  = "event.create"
+	AuditEventUpdate  = "event.update"
+	AuditEventDelete  = "event.delete"
+	AuditEventRestore = "event.restore"
+	AuditTrashPurge   = "trash.purge"
+	AuditUserCreate   = "user.create"
+	AuditUserUpdate   = "user.update"
+	AuditUserPassword = "user.password"
+	AuditUserUnlock   = "user.unlock"
+	AuditUserDelete   = "user.delete"
+	AuditLogin        = "login"
+	AuditLoginFailed  = "login.failed"
+	AuditLogout       = "logout"
+)
+
+var AuditActions = []string{
+	AuditPhotoUpload,
+	AuditPhotoUpdate,
+	AuditPhotoDelete,
+	AuditPhotoRestore,
+	AuditEventCreate,
+	AuditEventUpdate,
+	AuditEventDelete,
+	AuditEventRestore,
+	AuditTrashPurge,
+	AuditUserCreate,
+	AuditUserUpdate,
+	AuditUserPassword,
+	AuditUserUnlock,
+	AuditUserDelete,
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+}
+
+type AuditModelInterface interface {
+	Insert(entry *AuditEntry) error
+	GetFiltered(filters data.Filters) ([]*AuditEntry, data.Metadata, error)
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Entry of the audit log. The name of the actor is copied, so that it is kept
+// after the user is deleted or renamed
+type AuditEntry struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    *int // Nil for requests that are not logged in
+	ActorName  string
+	Action     string
+	TargetType string // "photo", "event" or "user"
+	TargetID   string
+	RequestID  string
+	IP         string
+	Before     json.RawMessage // State of the target before the action, nil if not relevant
+	After      json.RawMessage // State of the target after the action, nil if not relevant
+}
+
+// Insert the entry, the name of the actor is looked up if not set
+func (m *AuditModel) Insert(entry *AuditEntry) error {
+	query := `
+    INSERT INTO audit_log (actor_id, actor_name, action, target_type, target_id, request_id, ip, before, after)
+    VALUES ($1, COALESCE(NULLIF($2, ''), (SELECT name FROM users WHERE id = $1), ''), $3, $4, $5, $6, $7, $8, $9)
+    RETURNING id, created_at, actor_name
+    `
+
+	args := []any{
+		newNullInt(entry.ActorID),
+		entry.ActorName,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		entry.RequestID,
+		entry.IP,
+		nullJSON(entry.Before),
+		nullJSON(entry.After),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt, &entry.ActorName)
+}
+
+// Get the entries matching the filters, newest first. From is inclusive, To is exclusive
+func (m *AuditModel) GetFiltered(filters data.Filters) ([]*AuditEntry, data.Metadata, error) {
+	query := `
+    SELECT count(*) OVER(), id, created_at, actor_id, actor_name, action, target_type, target_id, request_id, ip, before, after
+    FROM audit_log
+    WHERE (action = $3 OR $3 = '')
+    AND (actor_id = $4 OR $4 = 0)
+    AND (created_at >= $5 OR $5 IS NULL)
+    AND (created_at < $6 OR $6 IS NULL)
+    ORDER BY created_at DESC, id DESC
+    LIMIT $1 OFFSET $2
+    `
+
+	args := []any{
+		filters.Limit(),
+		filters.Offset(),
+		filters.Action,
+		filters.Actor,
+		newNullTime(filters.From),
+		newNullTime(filters.To),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.ActorName,
+			&entry.Action,
+			&entry.TargetType,
+			&entry.TargetID,
+			&entry.RequestID,
+			&entry.IP,
+			&before,
+			&after,
+		)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		entry.Before = before
+		entry.After = after
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// Empty json is stored as NULL
+func nullJSON(j json.RawMessage) any {
+	if len(j) == 0 {
+		return nil
+	}
+
+	return []byte(j)
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sitoWow/internal/validator"
+	"time"
+)
+
+type CommentModelInterface interface {
+	Insert(comment *Comment) error
+	Update(comment *Comment) error
+	Delete(id int) error
+	SetHidden(id int, hidden bool) error
+	GetByID(id int) (*Comment, error)
+	GetByPhoto(photo int) ([]*Comment, error)
+}
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+type Comment struct {
+	ID         int
+	Photo      int
+	PhotoFile  string
+	PhotoEvent int
+	Parent     *int
+	Author     int
+	AuthorName string
+	Body       string
+	CreatedAt  time.Time
+	EditedAt   *time.Time
+	Hidden     bool
+	Deleted    bool
+	Version    int
+	Edits      []*CommentEdit
+	Replies    []*Comment
+	CanEdit    bool
+	CanHide    bool
+}
+
+// A previous version of a comment's body
+type CommentEdit struct {
+	Body     string
+	EditedAt time.Time
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.CheckField(validator.NotBlank(comment.Body), "body", "This field cannot be blank")
+	v.CheckField(validator.CharsCount(comment.Body, 0, 2000), "body", "Comment must be at most 2000 characters long")
+}
+
+func (m *CommentModel) Insert(comment *Comment) error {
+	query := `
+    INSERT INTO comments (photo, parent, author, body)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at, version
+    `
+
+	args := []any{comment.Photo, newNullInt(comment.Parent), comment.Author, comment.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Update the comment's body, saving the previous one in its edit history
+func (m *CommentModel) Update(comment *Comment) error {
+	historyQuery := `
+    INSERT INTO comment_edits (comment, body)
+    SELECT id, body
+    FROM comments
+    WHERE id = $1 AND version = $2
+    `
+
+	query := `
+    UPDATE comments
+    SET body = $1, edited_at = NOW(), version = version + 1
+    WHERE id = $2 AND version = $3
+    RETURNING edited_at, version
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, historyQuery, comment.ID, comment.Version)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, comment.Body, comment.ID, comment.Version).Scan(&comment.EditedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Comments are only marked as deleted, so that their replies are kept
+func (m *CommentModel) Delete(id int) error {
+	query := `
+    UPDATE comments
+    SET deleted = true, version = version + 1
+    WHERE id = $1 AND deleted = false
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *CommentModel) SetHidden(id int, hidden bool) error {
+	query := `
+    UPDATE comments
+    SET hidden = $1, version = version + 1
+    WHERE id = $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, hidden, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *CommentModel) GetByID(id int) (*Comment, error) {
+	query := `
+    SELECT comments.id, photo, photos.file_name, photos.event, parent, author, users.name, body,
+        comments.created_at, edited_at, hidden, deleted, comments.version
+    FROM comments
+        JOIN photos ON comments.photo = photos.id
+        JOIN users ON comments.author = users.id
+    WHERE comments.id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var comment Comment
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&comment.ID,
+		&comment.Photo,
+		&comment.PhotoFile,
+		&comment.PhotoEvent,
+		&comment.Parent,
+		&comment.Author,
+		&comment.AuthorName,
+		&comment.Body,
+		&comment.CreatedAt,
+		&comment.EditedAt,
+		&comment.Hidden,
+		&comment.Deleted,
+		&comment.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// Get all comments of a photo ordered by creation, along with their edit history.
+// Comments are returned as a flat list, replies are not filled
+func (m *CommentModel) GetByPhoto(photo int) ([]*Comment, error) {
+	query := `
+    SELECT comments.id, photo, photos.file_name, photos.event, parent, author, users.name, body,
+        comments.created_at, edited_at, hidden, deleted, comments.version
+    FROM comments
+        JOIN photos ON comments.photo = photos.id
+        JOIN users ON comments.author = users.id
+    WHERE photo = $1
+    ORDER BY comments.created_at ASC, comments.id ASC
+    `
+
+	editsQuery := `
+    SELECT comment, comment_edits.body, comment_edits.edited_at
+    FROM comment_edits JOIN comments ON comment_edits.comment = comments.id
+    WHERE comments.photo = $1
+    ORDER BY comment_edits.edited_at ASC, comment_edits.id ASC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, photo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	byID := make(map[int]*Comment)
+
+	for rows.Next() {
+		var comment Comment
+
+		err := rows.Scan(
+			&comment.ID,
+			&comment.Photo,
+			&comment.PhotoFile,
+			&comment.PhotoEvent,
+			&comment.Parent,
+			&comment.Author,
+			&comment.AuthorName,
+			&comment.Body,
+			&comment.CreatedAt,
+			&comment.EditedAt,
+			&comment.Hidden,
+			&comment.Deleted,
+			&comment.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, &comment)
+		byID[comment.ID] = &comment
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	editRows, err := m.DB.QueryContext(ctx, editsQuery, photo)
+	if err != nil {
+		return nil, err
+	}
+	defer editRows.Close()
+
+	for editRows.Next() {
+		var id int
+		var edit CommentEdit
+
+		err := editRows.Scan(&id, &edit.Body, &edit.EditedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if comment, ok := byID[id]; ok {
+			comment.Edits = append(comment.Edits, &edit)
+		}
+	}
+
+	if err = editRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
@@ -4,7 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sitoWow/internal/data"
+	"sitoWow/internal/validator"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type EventModelInterface interface {
@@ -13,6 +19,9 @@ type EventModelInterface interface {
 	Delete(id int) error
 	GetByID(id int) (*Event, error)
 	GetAll() ([]*Event, error)
+	GetFiltered(filters data.Filters, ids []int) ([]*Event, data.Metadata, error)
+	GetAncestors(id int) ([]*Event, error)
+	GetDescendants(id int) ([]*Event, error)
 }
 
 type EventModel struct {
@@ -20,23 +29,69 @@ type EventModel struct {
 }
 
 type Event struct {
-	ID      int
-	Name    string
-	Date    *time.Time
-	Version int
+	ID          int
+	Name        string
+	Description string
+	Date        *time.Time // Start date
+	EndDate     *time.Time
+	Location    string
+	Latitude    *float32
+	Longitude   *float32
+	Parent      *int
+	Category    string // Only used by top level events
+	Cover       *int   // Photo shown first for the event, chosen by admins
+	Highlights  []int  // Other photos shown on the home page, chosen by admins
+	Version     int
+}
+
+func ValidateEvent(v *validator.Validator, event *Event) {
+	v.CheckField(validator.NotBlank(event.Name), "name", "This field must not be empty")
+	// Try to prevent path traversal attacks
+	v.CheckField(!strings.Contains(event.Name, ".."), "name", "This field must not contain the string '..'")
+	v.CheckField(validator.CharsCount(event.Description, 0, 5000), "description", "Description must be at most 5000 characters long")
+	v.CheckField(validator.CharsCount(event.Location, 0, 500), "location", "Location must be at most 500 characters long")
+	v.CheckField(validator.CharsCount(event.Category, 0, 500), "category", "Category must be at most 500 characters long")
+	v.CheckField(event.Parent == nil || *event.Parent != event.ID, "parent", "An event cannot be its own parent")
+
+	if event.EndDate != nil {
+		v.CheckField(event.Date != nil, "end_date", "The start date must be set too")
+		v.CheckField(event.Date == nil || !event.EndDate.Before(*event.Date), "end_date", "End date must not be before the start date")
+	}
+
+	v.CheckField((event.Latitude == nil) == (event.Longitude == nil), "latitude", "Latitude and longitude must be set together")
+	if event.Latitude != nil {
+		v.CheckField(*event.Latitude >= -90 && *event.Latitude <= 90, "latitude", "Latitude must be between -90 and 90")
+	}
+	if event.Longitude != nil {
+		v.CheckField(*event.Longitude >= -180 && *event.Longitude <= 180, "longitude", "Longitude must be between -180 and 180")
+	}
 }
 
 func (m *EventModel) Insert(event *Event) error {
 	query := `
-    INSERT INTO events (name, day)
-    VALUES ($1, $2)
+    INSERT INTO events (name, description, day, end_day, location, latitude, longitude, parent, category, cover, highlights)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
     RETURNING id, version
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, event.Name, newNullTime(event.Date)).Scan(&event.ID, &event.Version)
+	args := []any{
+		event.Name,
+		event.Description,
+		newNullTime(event.Date),
+		newNullTime(event.EndDate),
+		event.Location,
+		newNullFloat(event.Latitude),
+		newNullFloat(event.Longitude),
+		newNullInt(event.Parent),
+		event.Category,
+		newNullInt(event.Cover),
+		pq.Int64Array(newInt64s(event.Highlights)),
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.Version)
 	if err != nil {
 		return err
 	}
@@ -47,15 +102,32 @@ func (m *EventModel) Insert(event *Event) error {
 func (m *EventModel) Update(event *Event) error {
 	query := `
     UPDATE events
-    SET name = $1, day = $2, version = version + 1
-    WHERE id = $3 AND version = $4
+    SET name = $1, description = $2, day = $3, end_day = $4, location = $5, latitude = $6, longitude = $7,
+        parent = $8, category = $9, cover = $10, highlights = $11, version = version + 1
+    WHERE id = $12 AND version = $13
     RETURNING version
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, event.Name, newNullTime(event.Date), event.ID, event.Version).Scan(&event.Version)
+	args := []any{
+		event.Name,
+		event.Description,
+		newNullTime(event.Date),
+		newNullTime(event.EndDate),
+		event.Location,
+		newNullFloat(event.Latitude),
+		newNullFloat(event.Longitude),
+		newNullInt(event.Parent),
+		event.Category,
+		newNullInt(event.Cover),
+		pq.Int64Array(newInt64s(event.Highlights)),
+		event.ID,
+		event.Version,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&event.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -93,9 +165,39 @@ func (m *EventModel) Delete(id int) error {
 	return nil
 }
 
+const eventColumns = `id, name, description, day, end_day, location, latitude, longitude, parent, category, cover, highlights, version`
+
+func scanEvent(row interface{ Scan(...any) error }) (*Event, error) {
+	var event Event
+	var highlights pq.Int64Array
+
+	err := row.Scan(
+		&event.ID,
+		&event.Name,
+		&event.Description,
+		&event.Date,
+		&event.EndDate,
+		&event.Location,
+		&event.Latitude,
+		&event.Longitude,
+		&event.Parent,
+		&event.Category,
+		&event.Cover,
+		&highlights,
+		&event.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Highlights = newInts(highlights)
+
+	return &event, nil
+}
+
 func (m *EventModel) GetByID(id int) (*Event, error) {
 	query := `
-    SELECT id, name, day, version
+    SELECT ` + eventColumns + `
     FROM events
     WHERE id = $1
     `
@@ -103,9 +205,7 @@ func (m *EventModel) GetByID(id int) (*Event, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var event Event
-
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&event.ID, &event.Name, &event.Date, &event.Version)
+	event, err := scanEvent(m.DB.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrRecordNotFound
@@ -114,13 +214,13 @@ func (m *EventModel) GetByID(id int) (*Event, error) {
 		return nil, err
 	}
 
-	return &event, nil
+	return event, nil
 }
 
 // Get all events ordered by descending day
 func (m *EventModel) GetAll() ([]*Event, error) {
 	query := `
-    SELECT id, name, day, version
+    SELECT ` + eventColumns + `
     FROM events
     ORDER BY day DESC, name ASC
     `
@@ -137,19 +237,139 @@ func (m *EventModel) GetAll() ([]*Event, error) {
 	var events []*Event
 
 	for rows.Next() {
-		var event Event
-
-		rows.Scan(
-			&event.ID,
-			&event.Name,
-			&event.Date,
-			&event.Version,
-		)
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Get a page of events, sorted according to the filters. If ids is not nil, only those events are included
+func (m *EventModel) GetFiltered(filters data.Filters, ids []int) ([]*Event, data.Metadata, error) {
+	// Sort column comes from the safelist, so it can be put in the query
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), `+eventColumns+`
+    FROM events
+    WHERE id = ANY($3) OR $3 IS NULL
+    ORDER BY %s %s NULLS LAST, id ASC
+    LIMIT $1 OFFSET $2
+    `, filters.SortColumn(), filters.SortDirection())
+
+	var idsArray pq.Int64Array
+	if ids != nil {
+		idsArray = newInt64s(ids)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.Limit(), filters.Offset(), idsArray)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*Event{}
+
+	for rows.Next() {
+		var total int
+		event, err := scanEvent(scanFunc(func(dest ...any) error {
+			return rows.Scan(append([]any{&total}, dest...)...)
+		}))
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		totalRecords = total
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// Adapts a function to the interface scanEvent expects
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+// Get the ancestors of an event, starting from the top level one
+func (m *EventModel) GetAncestors(id int) ([]*Event, error) {
+	query := `
+    WITH RECURSIVE ancestors AS (
+        SELECT e.*, 0 AS depth
+        FROM events AS e
+        WHERE e.id = (SELECT parent FROM events WHERE id = $1)
+        UNION ALL
+        SELECT e.*, a.depth + 1
+        FROM events AS e JOIN ancestors AS a ON e.id = a.parent
+        WHERE a.depth < 100
+    )
+    SELECT ` + eventColumns + `
+    FROM ancestors
+    ORDER BY depth DESC
+    `
+	// The depth limit prevents infinite loops, should a cycle ever be saved
+
+	return m.query(query, id)
+}
+
+// Get all the events below an event in the hierarchy, the event itself excluded
+func (m *EventModel) GetDescendants(id int) ([]*Event, error) {
+	query := `
+    WITH RECURSIVE descendants AS (
+        SELECT e.*, 0 AS depth
+        FROM events AS e
+        WHERE e.parent = $1
+        UNION ALL
+        SELECT e.*, d.depth + 1
+        FROM events AS e JOIN descendants AS d ON e.parent = d.id
+        WHERE d.depth < 100
+    )
+    SELECT ` + eventColumns + `
+    FROM descendants
+    ORDER BY depth ASC, day DESC, name ASC
+    `
+	// The depth limit prevents infinite loops, should a cycle ever be saved
+
+	return m.query(query, id)
+}
+
+func (m *EventModel) query(query string, args ...any) ([]*Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		events = append(events, &event)
+		events = append(events, event)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"maps"
+	"sitoWow/internal/validator"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateEvent(t *testing.T) {
+	ptr := func(v float32) *float32 { return &v }
+	day := func(d int) *time.Time {
+		t := time.Date(2024, time.May, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	parent := func(id int) *int { return &id }
+
+	tests := []struct {
+		name   string
+		event  Event
+		errors []string // Fields with an error
+	}{
+		{
+			name:  "valid",
+			event: Event{ID: 1, Name: "Gita", Date: day(1), EndDate: day(3), Latitude: ptr(45), Longitude: ptr(9), Parent: parent(2)},
+		},
+		{
+			name:   "blank name",
+			event:  Event{Name: "  "},
+			errors: []string{"name"},
+		},
+		{
+			name:   "path traversal in name",
+			event:  Event{Name: "../photos"},
+			errors: []string{"name"},
+		},
+		{
+			name:   "description too long",
+			event:  Event{Name: "Gita", Description: strings.Repeat("a", 5001)},
+			errors: []string{"description"},
+		},
+		{
+			name:   "location and category too long",
+			event:  Event{Name: "Gita", Location: strings.Repeat("a", 501), Category: strings.Repeat("a", 501)},
+			errors: []string{"category", "location"},
+		},
+		{
+			name:   "own parent",
+			event:  Event{ID: 1, Name: "Gita", Parent: parent(1)},
+			errors: []string{"parent"},
+		},
+		{
+			name:   "end date without start date",
+			event:  Event{Name: "Gita", EndDate: day(3)},
+			errors: []string{"end_date"},
+		},
+		{
+			name:   "end date before start date",
+			event:  Event{Name: "Gita", Date: day(3), EndDate: day(1)},
+			errors: []string{"end_date"},
+		},
+		{
+			name:  "single day event",
+			event: Event{Name: "Gita", Date: day(3), EndDate: day(3)},
+		},
+		{
+			name:   "latitude without longitude",
+			event:  Event{Name: "Gita", Latitude: ptr(45)},
+			errors: []string{"latitude"},
+		},
+		{
+			name:   "coordinates out of range",
+			event:  Event{Name: "Gita", Latitude: ptr(90.5), Longitude: ptr(-180.5)},
+			errors: []string{"latitude", "longitude"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &validator.Validator{}
+			ValidateEvent(v, &tt.event)
+
+			got := slices.Sorted(maps.Keys(v.FieldErrors))
+			if !slices.Equal(got, tt.errors) {
+				t.Errorf("got errors on %v, want %v", got, tt.errors)
+			}
+		})
+	}
+}
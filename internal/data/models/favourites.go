@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type FavouriteModelInterface interface {
+	Toggle(user, photo int) (bool, error)
+	Status(user, photo int) (bool, int, error)
+	GetAll(user int) ([]*Photo, error)
+	GetEventStatus(user, event int) (map[int]bool, map[int]int, error)
+}
+
+type FavouriteModel struct {
+	DB *sql.DB
+}
+
+// Add the photo to the user's favourites if it's not there, remove it otherwise.
+// Returns whether the photo is a favourite after the toggle
+func (m *FavouriteModel) Toggle(user, photo int) (bool, error) {
+	deleteQuery := `
+    DELETE FROM favourites
+    WHERE user_id = $1 AND photo = $2
+    `
+
+	insertQuery := `
+    INSERT INTO favourites (user_id, photo)
+    VALUES ($1, $2)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, deleteQuery, user, photo)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	_, err = m.DB.ExecContext(ctx, insertQuery, user, photo)
+	if err != nil {
+		if err.Error() == `pq: insert or update on table "favourites" violates foreign key constraint "fk_photo_id"` {
+			return false, ErrRecordNotFound
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Returns whether the photo is one of the user's favourites, and how many users have it as favourite
+func (m *FavouriteModel) Status(user, photo int) (bool, int, error) {
+	query := `
+    SELECT COALESCE(bool_or(user_id = $1), false), COUNT(*)
+    FROM favourites
+    WHERE photo = $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var isFavourite bool
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, user, photo).Scan(&isFavourite, &count)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return isFavourite, count, nil
+}
+
+// Get the user's favourite photos, most recently added first
+func (m *FavouriteModel) GetAll(user int) ([]*Photo, error) {
+	query := `
+    SELECT photos.id, file_name, photos.created_at, taken_at, latitude, longitude, event
+    FROM favourites JOIN photos ON favourites.photo = photos.id
+    WHERE favourites.user_id = $1
+    ORDER BY favourites.created_at DESC, photos.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	photos := []*Photo{}
+
+	for rows.Next() {
+		photo := Photo{IsFavourite: true}
+
+		err := rows.Scan(
+			&photo.ID,
+			&photo.FileName,
+			&photo.CreatedAt,
+			&photo.TakenAt,
+			&photo.Latitude,
+			&photo.Longitude,
+			&photo.Event,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		photos = append(photos, &photo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// Returns, for the photos of an event, which ones are favourites of the user,
+// and how many favourites each photo has (photos with none are not present)
+func (m *FavouriteModel) GetEventStatus(user, event int) (map[int]bool, map[int]int, error) {
+	query := `
+    SELECT favourites.photo, bool_or(favourites.user_id = $1), COUNT(*)
+    FROM favourites JOIN photos ON favourites.photo = photos.id
+    WHERE photos.event = $2
+    GROUP BY favourites.photo
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user, event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	favourites := make(map[int]bool)
+	counts := make(map[int]int)
+
+	for rows.Next() {
+		var photo, count int
+		var isFavourite bool
+
+		err := rows.Scan(&photo, &isFavourite, &count)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if isFavourite {
+			favourites[photo] = true
+		}
+		counts[photo] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return favourites, counts, nil
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sitoWow/internal/validator"
+	"time"
+)
+
+type GroupModelInterface interface {
+	Insert(group *Group) error
+	Delete(id int) error
+	GetAll() ([]*Group, error)
+	AddMember(group, user int) error
+	RemoveMember(group, user int) error
+}
+
+type GroupModel struct {
+	DB *sql.DB
+}
+
+// Users that share access to events, e.g. a family or a club
+type Group struct {
+	ID        int
+	Name      string
+	CreatedAt time.Time
+	Members   []*User
+}
+
+func ValidateGroup(v *validator.Validator, group *Group) {
+	v.CheckField(validator.NotBlank(group.Name), "name", "This field cannot be blank")
+	v.CheckField(validator.CharsCount(group.Name, 0, 500), "name", "Name must be at most 500 characters long")
+}
+
+func (m *GroupModel) Insert(group *Group) error {
+	query := `
+    INSERT INTO groups (name)
+    VALUES ($1)
+    RETURNING id, created_at
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, group.Name).Scan(&group.ID, &group.CreatedAt)
+	if err != nil {
+		if err.Error() == `pq: un valore chiave duplicato viola il vincolo univoco "groups_name_key"` ||
+			err.Error() == `pq: duplicate key value violates unique constraint "groups_name_key"` {
+			return ErrDuplicateName
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (m *GroupModel) Delete(id int) error {
+	query := `
+    DELETE FROM groups
+    WHERE id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Get all groups ordered by name, along with their members
+func (m *GroupModel) GetAll() ([]*Group, error) {
+	groupsQuery := `
+    SELECT id, name, created_at
+    FROM groups
+    ORDER BY name ASC
+    `
+
+	membersQuery := `
+    SELECT gm.group_id, u.id, u.name, u.role
+    FROM group_members AS gm JOIN users AS u ON u.id = gm.user_id
+    ORDER BY u.name ASC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, groupsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*Group{}
+	byID := make(map[int]*Group)
+
+	for rows.Next() {
+		var group Group
+
+		err := rows.Scan(&group.ID, &group.Name, &group.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, &group)
+		byID[group.ID] = &group
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	memberRows, err := m.DB.QueryContext(ctx, membersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var groupID int
+		var user User
+
+		err := memberRows.Scan(&groupID, &user.ID, &user.Name, &user.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		if group, ok := byID[groupID]; ok {
+			group.Members = append(group.Members, &user)
+		}
+	}
+
+	if err = memberRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// Adding a user that is already a member does nothing
+func (m *GroupModel) AddMember(group, user int) error {
+	query := `
+    INSERT INTO group_members (group_id, user_id)
+    VALUES ($1, $2)
+    ON CONFLICT DO NOTHING
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, group, user)
+	if err != nil {
+		if err.Error() == `pq: insert or update on table "group_members" violates foreign key constraint "fk_group_id"` ||
+			err.Error() == `pq: insert or update on table "group_members" violates foreign key constraint "fk_user_id"` {
+			return ErrRecordNotFound
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (m *GroupModel) RemoveMember(group, user int) error {
+	query := `
+    DELETE FROM group_members
+    WHERE group_id = $1 AND user_id = $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, group, user)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
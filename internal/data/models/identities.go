@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type IdentityModelInterface interface {
+	Insert(identity *Identity) error
+	Get(issuer, subject string) (*Identity, error)
+	GetForUser(user int) ([]*Identity, error)
+	Delete(user int, issuer, subject string) error
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Account of an OpenID Connect provider linked to a user. Subject is the id of the account in the provider
+type Identity struct {
+	Issuer    string
+	Subject   string
+	UserID    int
+	Email     string
+	CreatedAt time.Time
+}
+
+// Returns ErrDuplicateName if the account is already linked to a user
+func (m *IdentityModel) Insert(identity *Identity) error {
+	query := `
+    INSERT INTO identities (issuer, subject, user_id, email)
+    VALUES ($1, $2, $3, $4)
+    RETURNING created_at
+    `
+
+	args := []any{identity.Issuer, identity.Subject, identity.UserID, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: un valore chiave duplicato viola il vincolo univoco "identities_pkey"` ||
+			err.Error() == `pq: duplicate key value violates unique constraint "identities_pkey"`:
+			return ErrDuplicateName
+		case err.Error() == `pq: insert or update on table "identities" violates foreign key constraint "fk_user_id"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *IdentityModel) Get(issuer, subject string) (*Identity, error) {
+	query := `
+    SELECT issuer, subject, user_id, email, created_at
+    FROM identities
+    WHERE issuer = $1 AND subject = $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var identity Identity
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&identity.Issuer,
+		&identity.Subject,
+		&identity.UserID,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// Get the identities linked to the user, oldest first
+func (m *IdentityModel) GetForUser(user int) ([]*Identity, error) {
+	query := `
+    SELECT issuer, subject, user_id, email, created_at
+    FROM identities
+    WHERE user_id = $1
+    ORDER BY created_at ASC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+
+		err := rows.Scan(
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.UserID,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// Unlink the identity, ErrRecordNotFound if it is not linked to the user
+func (m *IdentityModel) Delete(user int, issuer, subject string) error {
+	query := `
+    DELETE FROM identities
+    WHERE user_id = $1 AND issuer = $2 AND subject = $3
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, user, issuer, subject)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
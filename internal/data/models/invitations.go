@@ -0,0 +1,269 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"sitoWow/internal/validator"
+	"time"
+)
+
+type InvitationModelInterface interface {
+	Insert(invitation *Invitation) error
+	GetByToken(plaintext string) (*Invitation, error)
+	GetAll() ([]*Invitation, error)
+	Accept(invitation *Invitation, user *User) error
+	Delete(id int) error
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Single use link to create an account with a preset role. Like share links,
+// only the hash of the token in the url is stored
+type Invitation struct {
+	ID            int
+	Plaintext     string
+	Hash          []byte
+	Role          string
+	CreatedBy     *int
+	CreatedByName string
+	CreatedAt     time.Time
+	Expiry        time.Time
+	UsedBy        *int
+	UsedByName    string
+	UsedAt        *time.Time // Nil while the invitation is pending
+}
+
+func (i *Invitation) Used() bool {
+	return i.UsedAt != nil
+}
+
+func (i *Invitation) Expired() bool {
+	return i.Expiry.Before(time.Now())
+}
+
+func GenerateInvitation(role string, createdBy int, expiry time.Time) (*Invitation, error) {
+	invitation := &Invitation{
+		Role:      role,
+		CreatedBy: &createdBy,
+		Expiry:    expiry,
+	}
+
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(invitation.Plaintext))
+	invitation.Hash = hash[:]
+
+	return invitation, nil
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	v.CheckField(validator.PermittedValue(invitation.Role, Roles...), "role", "Invalid role")
+	v.CheckField(invitation.Expiry.After(time.Now()), "expiry", "Expiry must be in the future")
+}
+
+func (m *InvitationModel) Insert(invitation *Invitation) error {
+	query := `
+    INSERT INTO invitations (hash, role, created_by, expiry)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at
+    `
+
+	args := []any{invitation.Hash, invitation.Role, newNullInt(invitation.CreatedBy), invitation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+const invitationColumns = `i.id, i.role, i.created_by, COALESCE(c.name, ''), i.created_at, i.expiry, i.used_by, COALESCE(u.name, ''), i.used_at`
+
+const invitationTables = `
+    invitations AS i
+    LEFT JOIN users AS c ON c.id = i.created_by
+    LEFT JOIN users AS u ON u.id = i.used_by`
+
+func scanInvitation(row interface{ Scan(...any) error }) (*Invitation, error) {
+	var invitation Invitation
+
+	err := row.Scan(
+		&invitation.ID,
+		&invitation.Role,
+		&invitation.CreatedBy,
+		&invitation.CreatedByName,
+		&invitation.CreatedAt,
+		&invitation.Expiry,
+		&invitation.UsedBy,
+		&invitation.UsedByName,
+		&invitation.UsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// Get the invitation matching the plaintext token, if it is still pending and has not expired
+func (m *InvitationModel) GetByToken(plaintext string) (*Invitation, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+    SELECT ` + invitationColumns + `
+    FROM ` + invitationTables + `
+    WHERE i.hash = $1 AND i.used_at IS NULL AND i.expiry > NOW()
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	invitation, err := scanInvitation(m.DB.QueryRowContext(ctx, query, hash[:]))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	invitation.Plaintext = plaintext
+	invitation.Hash = hash[:]
+
+	return invitation, nil
+}
+
+// Get all the invitations, newest first. Used and expired ones are included
+func (m *InvitationModel) GetAll() ([]*Invitation, error) {
+	query := `
+    SELECT ` + invitationColumns + `
+    FROM ` + invitationTables + `
+    ORDER BY i.created_at DESC, i.id DESC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Create the user with the role of the invitation and mark the invitation as used by them, in a single transaction.
+// Returns ErrRecordNotFound if the invitation was used or expired in the meantime, and ErrDuplicateName if the name is taken
+func (m *InvitationModel) Accept(invitation *Invitation, user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locks the invitation, so that it cannot be accepted twice at the same time
+	query := `
+    SELECT role
+    FROM invitations
+    WHERE id = $1 AND used_at IS NULL AND expiry > NOW()
+    FOR UPDATE
+    `
+
+	err = tx.QueryRowContext(ctx, query, invitation.ID).Scan(&user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+
+		return err
+	}
+
+	query = `
+    INSERT INTO users (name, password_hash, role)
+    VALUES ($1, $2, $3)
+    RETURNING id, created_at, active, version
+    `
+
+	err = tx.QueryRowContext(ctx, query, user.Name, user.Password.hash, user.Role).Scan(&user.ID, &user.CreatedAt, &user.Active, &user.Version)
+	if err != nil {
+		if err.Error() == `pq: un valore chiave duplicato viola il vincolo univoco "users_name_key"` ||
+			err.Error() == `pq: duplicate key value violates unique constraint "users_name_key"` {
+			return ErrDuplicateName
+		}
+
+		return err
+	}
+
+	query = `
+    UPDATE invitations
+    SET used_by = $2, used_at = NOW()
+    WHERE id = $1
+    RETURNING used_at
+    `
+
+	err = tx.QueryRowContext(ctx, query, invitation.ID, user.ID).Scan(&invitation.UsedAt)
+	if err != nil {
+		return err
+	}
+
+	invitation.UsedBy = &user.ID
+	invitation.UsedByName = user.Name
+
+	return tx.Commit()
+}
+
+// Revoke a pending invitation. Used ones are kept, since they record who invited whom
+func (m *InvitationModel) Delete(id int) error {
+	query := `
+    DELETE FROM invitations
+    WHERE id = $1 AND used_at IS NULL
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
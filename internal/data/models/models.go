@@ -12,6 +12,7 @@ var (
 	ErrEditConflict       = errors.New("edit conflict")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidLatLon      = errors.New("Invalid latitude or longitude")
+	ErrLastAdmin          = errors.New("last admin")
 
 	ImageExtensions = []string{
 		".gif",
@@ -36,21 +37,47 @@ var (
 	}
 )
 
-const (
-	ADMIN_LEVEL = 10
-)
-
 type Models struct {
-	Users  UserModelInterface
-	Photos PhotoModelInterface
-	Events EventModelInterface
+	Users       UserModelInterface
+	Photos      PhotoModelInterface
+	Events      EventModelInterface
+	Favourites  FavouriteModelInterface
+	Comments    CommentModelInterface
+	Albums      AlbumModelInterface
+	Tokens      TokenModelInterface
+	Groups      GroupModelInterface
+	Permissions EventPermissionModelInterface
+	ShareLinks  ShareLinkModelInterface
+	Throttles   LoginThrottleModelInterface
+	TwoFactor   TwoFactorModelInterface
+	Settings    SettingModelInterface
+	Identities  IdentityModelInterface
+	Invitations InvitationModelInterface
+	Sessions    SessionModelInterface
+	Audit       AuditModelInterface
+	Trash       TrashModelInterface
 }
 
 func New(db *sql.DB) Models {
 	return Models{
-		Users:  &UserModel{DB: db},
-		Photos: &PhotoModel{DB: db},
-		Events: &EventModel{DB: db},
+		Users:       &UserModel{DB: db},
+		Photos:      &PhotoModel{DB: db},
+		Events:      &EventModel{DB: db},
+		Favourites:  &FavouriteModel{DB: db},
+		Comments:    &CommentModel{DB: db},
+		Albums:      &AlbumModel{DB: db},
+		Tokens:      &TokenModel{DB: db},
+		Groups:      &GroupModel{DB: db},
+		Permissions: &EventPermissionModel{DB: db},
+		ShareLinks:  &ShareLinkModel{DB: db},
+		Throttles:   &LoginThrottleModel{DB: db},
+		TwoFactor:   &TwoFactorModel{DB: db},
+		Settings:    &SettingModel{DB: db},
+		Identities:  &IdentityModel{DB: db},
+		Invitations: &InvitationModel{DB: db},
+		Sessions:    &SessionModel{DB: db},
+		Audit:       &AuditModel{DB: db},
+		Trash:       &TrashModel{DB: db},
 	}
 }
 
@@ -85,3 +112,20 @@ func newNullTime(t *time.Time) sql.NullTime {
 		Valid: true,
 	}
 }
+
+// Highlights are never stored as NULL, so the slice is never nil
+func newInt64s(n []int) []int64 {
+	res := make([]int64, len(n))
+	for i := range n {
+		res[i] = int64(n[i])
+	}
+	return res
+}
+
+func newInts(n []int64) []int {
+	res := make([]int, len(n))
+	for i := range n {
+		res[i] = int(n[i])
+	}
+	return res
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sitoWow/internal/validator"
+	"time"
+)
+
+// Access that can be granted on an event. Contributors can also upload photos to it
+const (
+	AccessView       = "view"
+	AccessContribute = "contribute"
+)
+
+type EventPermissionModelInterface interface {
+	Set(permission *EventPermission) error
+	Delete(id int) error
+	GetForEvent(event int) ([]*EventPermission, error)
+	GetAccess(user int, event *int) (map[int]string, error)
+}
+
+type EventPermissionModel struct {
+	DB *sql.DB
+}
+
+// Grants a user or a group access to an event and its sub-events.
+// Events without permissions inherit those of the closest ancestor that has some,
+// if none has them every user can view and contribute to them
+type EventPermission struct {
+	ID        int
+	Event     int
+	User      *int
+	Group     *int
+	Name      string // Name of the user or group
+	Access    string
+	CreatedAt time.Time
+}
+
+func ValidateEventPermission(v *validator.Validator, permission *EventPermission) {
+	v.CheckField((permission.User == nil) != (permission.Group == nil), "grantee", "You must choose either a user or a group")
+	v.CheckField(validator.PermittedValue(permission.Access, AccessView, AccessContribute), "access", "Invalid access")
+}
+
+// Grant the access, replacing the one the user or group already had on the event
+func (m *EventPermissionModel) Set(permission *EventPermission) error {
+	conflict := "(event, user_id)"
+	if permission.Group != nil {
+		conflict = "(event, group_id)"
+	}
+
+	query := `
+    INSERT INTO event_permissions (event, user_id, group_id, access)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT ` + conflict + ` DO UPDATE SET access = EXCLUDED.access
+    RETURNING id, created_at
+    `
+
+	args := []any{permission.Event, newNullInt(permission.User), newNullInt(permission.Group), permission.Access}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&permission.ID, &permission.CreatedAt)
+	if err != nil {
+		switch err.Error() {
+		case `pq: insert or update on table "event_permissions" violates foreign key constraint "fk_event_id"`,
+			`pq: insert or update on table "event_permissions" violates foreign key constraint "fk_user_id"`,
+			`pq: insert or update on table "event_permissions" violates foreign key constraint "fk_group_id"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *EventPermissionModel) Delete(id int) error {
+	query := `
+    DELETE FROM event_permissions
+    WHERE id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Get the permissions set directly on the event, groups first
+func (m *EventPermissionModel) GetForEvent(event int) ([]*EventPermission, error) {
+	query := `
+    SELECT p.id, p.event, p.user_id, p.group_id, COALESCE(u.name, g.name), p.access, p.created_at
+    FROM event_permissions AS p
+        LEFT JOIN users AS u ON u.id = p.user_id
+        LEFT JOIN groups AS g ON g.id = p.group_id
+    WHERE p.event = $1
+    ORDER BY p.group_id IS NULL, COALESCE(u.name, g.name)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []*EventPermission{}
+
+	for rows.Next() {
+		var p EventPermission
+
+		err := rows.Scan(&p.ID, &p.Event, &p.User, &p.Group, &p.Name, &p.Access, &p.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// The access given by the permissions of the event governing another one, the closest one up the
+// hierarchy that has any. Events not governed by any are public. Empty if the user has no access
+func accessFromPermissions(public, contribute, granted bool) string {
+	switch {
+	case public || contribute:
+		return AccessContribute
+	case granted:
+		return AccessView
+	default:
+		return ""
+	}
+}
+
+// Get the access the user has on the event, or on all events if event is nil.
+// Events the user cannot access are not in the map
+func (m *EventPermissionModel) GetAccess(user int, event *int) (map[int]string, error) {
+	// For each event, walk up the hierarchy until an event with permissions is found:
+	// its permissions are the ones that apply.
+	// The depth limit prevents infinite loops, should a cycle ever be saved
+	query := `
+    WITH RECURSIVE ancestors AS (
+        SELECT id AS event, id, parent, 0 AS depth
+        FROM events
+        WHERE id = $1 OR $1 IS NULL
+        UNION ALL
+        SELECT a.event, e.id, e.parent, a.depth + 1
+        FROM events AS e JOIN ancestors AS a ON e.id = a.parent
+        WHERE a.depth < 100
+    ), governing AS (
+        SELECT DISTINCT ON (a.event) a.event, a.id
+        FROM ancestors AS a
+        WHERE EXISTS (SELECT 1 FROM event_permissions AS p WHERE p.event = a.id)
+        ORDER BY a.event, a.depth ASC
+    )
+    SELECT e.id,
+        g.id IS NULL,
+        COALESCE(bool_or(p.access = 'contribute'), false),
+        count(p.id) > 0
+    FROM events AS e
+        LEFT JOIN governing AS g ON g.event = e.id
+        LEFT JOIN event_permissions AS p ON p.event = g.id
+            AND (p.user_id = $2 OR p.group_id IN (SELECT group_id FROM group_members WHERE user_id = $2))
+    WHERE e.id = $1 OR $1 IS NULL
+    GROUP BY e.id, g.id
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, newNullInt(event), user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	access := make(map[int]string)
+
+	for rows.Next() {
+		var id int
+		var public, contribute, granted bool
+
+		err := rows.Scan(&id, &public, &contribute, &granted)
+		if err != nil {
+			return nil, err
+		}
+
+		a := accessFromPermissions(public, contribute, granted)
+		if a != "" {
+			access[id] = a
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestAccessFromPermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		public     bool // No event up the hierarchy has permissions
+		contribute bool // The user or one of their groups can contribute to the governing event
+		granted    bool // The user or one of their groups has any permission on the governing event
+		want       string
+	}{
+		{name: "public event", public: true, want: AccessContribute},
+		{name: "not granted", want: ""},
+		{name: "view granted", granted: true, want: AccessView},
+		{name: "contribute granted", contribute: true, granted: true, want: AccessContribute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := accessFromPermissions(tt.public, tt.contribute, tt.granted)
+			if got != tt.want {
+				t.Errorf("accessFromPermissions(%v, %v, %v) = %q, want %q", tt.public, tt.contribute, tt.granted, got, tt.want)
+			}
+		})
+	}
+}
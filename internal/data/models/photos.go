@@ -4,19 +4,28 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"sitoWow/internal/data"
+	"sitoWow/internal/validator"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type PhotoModelInterface interface {
 	Insert(photo *Photo) error
+	Update(photo *Photo) error
 	Delete(id int) error
 	DeleteByFile(file string) error
 	GetByFile(file string) (*Photo, error)
-	GetFiltered(event *int, filters data.Filters) ([]*Photo, data.Metadata, error) // Not used
+	GetFiltered(event *int, filters data.Filters) ([]*Photo, data.Metadata, error)
+	GetByIDs(ids []int) ([]*Photo, error)
+	GetUploaders(event int) ([]*User, error)
 	GetAll(event *int) ([]*Photo, error)
 	Summary(n int) ([]*Photo, error)
+	GetTimeline(before time.Time, beforeID int, n int) ([]*Photo, error)
+	GetTimelineMonths() ([]*TimelineMonth, error)
+	GetClusters(bbox BoundingBox, cellSize float64) ([]*PhotoCluster, error)
+	SetLocations(photos []*Photo) error
 }
 
 type PhotoModel struct {
@@ -32,14 +41,39 @@ type Photo struct {
 	Latitude     *float32
 	Longitude    *float32
 	Event        int
+	Uploader     *int
 	PreviousFile *string
 	NextFile     *string
+	IsFavourite  bool
+	Favourites   int
+}
+
+// Number of photos taken in a month
+type TimelineMonth struct {
+	Month time.Time
+	Count int
+}
+
+// Area of the map, in degrees
+type BoundingBox struct {
+	West  float64
+	South float64
+	East  float64
+	North float64
+}
+
+// Geotagged photos close to each other. Photo is the most recent of them
+type PhotoCluster struct {
+	Latitude  float64
+	Longitude float64
+	Count     int
+	Photo     *Photo
 }
 
 func (m *PhotoModel) Insert(photo *Photo) error {
 	query := `
-    INSERT INTO photos (file_name, taken_at, latitude, longitude, event)
-    VALUES ($1, $2, $3, $4, $5)
+    INSERT INTO photos (file_name, taken_at, latitude, longitude, event, uploader)
+    VALUES ($1, $2, $3, $4, $5, $6)
     RETURNING id, created_at
     `
 
@@ -49,6 +83,7 @@ func (m *PhotoModel) Insert(photo *Photo) error {
 		newNullFloat(photo.Latitude),
 		newNullFloat(photo.Longitude),
 		photo.Event,
+		newNullInt(photo.Uploader),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -70,6 +105,91 @@ func (m *PhotoModel) Insert(photo *Photo) error {
 	return nil
 }
 
+func ValidatePhoto(v *validator.Validator, photo *Photo) {
+	v.CheckField((photo.Latitude == nil) == (photo.Longitude == nil), "latitude", "Latitude and longitude must be set together")
+	if photo.Latitude != nil {
+		v.CheckField(*photo.Latitude >= -90 && *photo.Latitude <= 90, "latitude", "Latitude must be between -90 and 90")
+	}
+	if photo.Longitude != nil {
+		v.CheckField(*photo.Longitude >= -180 && *photo.Longitude <= 180, "longitude", "Longitude must be between -180 and 180")
+	}
+}
+
+// Update the time the photo was taken and its coordinates
+func (m *PhotoModel) Update(photo *Photo) error {
+	query := `
+    UPDATE photos
+    SET taken_at = $1, latitude = $2, longitude = $3
+    WHERE id = $4
+    `
+
+	args := []any{
+		newNullTime(photo.TakenAt),
+		newNullFloat(photo.Latitude),
+		newNullFloat(photo.Longitude),
+		photo.ID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		if err.Error() == `pq: new row for relation "photos" violates check constraint "valid_coords"` {
+			return ErrInvalidLatLon
+		}
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Set the coordinates of the photos, all of them or none
+func (m *PhotoModel) SetLocations(photos []*Photo) error {
+	query := `
+    UPDATE photos
+    SET latitude = $1, longitude = $2
+    WHERE id = $3
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, photo := range photos {
+		res, err := tx.ExecContext(ctx, query, newNullFloat(photo.Latitude), newNullFloat(photo.Longitude), photo.ID)
+		if err != nil {
+			if err.Error() == `pq: new row for relation "photos" violates check constraint "valid_coords"` {
+				return ErrInvalidLatLon
+			}
+			return err
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count != 1 {
+			return ErrRecordNotFound
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (m *PhotoModel) Delete(id int) error {
 	query := `
     DELETE FROM photos
@@ -209,19 +329,49 @@ func (m *PhotoModel) GetAll(event *int) ([]*Photo, error) {
 	return photos, nil
 }
 
+// Get a page of photos ordered by date, starting after filters.Cursor (keyset pagination on (taken_at, id)),
+// or from the first photo if the cursor is nil. Photos without a date come last, like in GetAll.
+// Photos are narrowed by the photo filters, the ones on the date exclude photos without a date.
+// The returned metadata has the cursor of the next page, if there is one
 func (m *PhotoModel) GetFiltered(event *int, filters data.Filters) ([]*Photo, data.Metadata, error) {
-	query := fmt.Sprintf(`
-    SELECT COUNT(*) OVER(), photos.id, file_name, created_at, taken_at, latitude, longitude, event
-    FROM photos LEFT JOIN events ON event = events.id
-    WHERE event = $1 OR $1 IS NULL
-    ORDER BY %s %s, taken_at ASC, photos.id
-    LIMIT $2 OFFSET $3
-    `, filters.SortColumn(), filters.SortDirection())
+	query := `
+    SELECT photos.id, file_name, created_at, taken_at, latitude, longitude, event
+    FROM photos
+    WHERE (event = $1 OR $1 IS NULL)
+        AND ($3 = 0
+            OR ($2::timestamptz IS NULL AND taken_at IS NULL AND photos.id > $3)
+            OR ($2::timestamptz IS NOT NULL AND ((taken_at, photos.id) > ($2, $3) OR taken_at IS NULL)))
+        AND ($5::timestamptz IS NULL OR taken_at >= $5)
+        AND ($6::timestamptz IS NULL OR taken_at <= $6)
+        AND ($7 = '' OR ($7 = 'video') = (COALESCE(lower(substring(file_name from '\.[^.]*$')), '') = ANY($8)))
+        AND ($9 = '' OR ($9 = 'with') = (latitude IS NOT NULL))
+        AND ($10 = 0 OR uploader = $10)
+    ORDER BY taken_at ASC, photos.id ASC
+    LIMIT $4
+    `
 	// IMPORTANT: the order by photos.id is necessary because in case of ties in ordering
 	// posgres makes no guarantee about what the ordering will be, so records could be
-	// seen as "moving around". Thus, we need an attribute that cannot be tied
+	// seen as "moving around". Thus, we need an attribute that cannot be tied.
+	// Unlike OFFSET, the cursor lets postgres skip directly to the page through index_date
 
-	args := []any{newNullInt(event), filters.Limit(), filters.Offset()}
+	cursor := filters.Cursor
+	if cursor == nil {
+		cursor = &data.Cursor{}
+	}
+
+	// One more photo than needed, to know if there is a next page
+	args := []any{
+		newNullInt(event),
+		newNullTime(cursor.TakenAt),
+		cursor.ID,
+		filters.Limit() + 1,
+		newNullTime(filters.From),
+		newNullTime(filters.To),
+		filters.MediaType,
+		pq.Array(VideoExtensions),
+		filters.Location,
+		filters.Uploader,
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -234,13 +384,11 @@ func (m *PhotoModel) GetFiltered(event *int, filters data.Filters) ([]*Photo, da
 	defer rows.Close()
 
 	photos := []*Photo{}
-	totalRecords := 0
 
 	for rows.Next() {
 		var photo Photo
 
 		err := rows.Scan(
-			&totalRecords,
 			&photo.ID,
 			&photo.FileName,
 			&photo.CreatedAt,
@@ -260,22 +408,85 @@ func (m *PhotoModel) GetFiltered(event *int, filters data.Filters) ([]*Photo, da
 		return nil, data.Metadata{}, err
 	}
 
-	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata := data.Metadata{PageSize: filters.PageSize}
+
+	if len(photos) > filters.Limit() {
+		photos = photos[:filters.Limit()]
+		last := photos[len(photos)-1]
+		metadata.NextCursor = data.Cursor{TakenAt: last.TakenAt, ID: last.ID}.String()
+	}
+
 	return photos, metadata, nil
 }
 
-// Returns the first n photos for each event, both ordered by date
+// Get the photos with the given ids, in no particular order
+func (m *PhotoModel) GetByIDs(ids []int) ([]*Photo, error) {
+	query := `
+    SELECT id, file_name, created_at, taken_at, latitude, longitude, event
+    FROM photos
+    WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Int64Array(newInt64s(ids)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	photos := []*Photo{}
+
+	for rows.Next() {
+		var photo Photo
+
+		err := rows.Scan(
+			&photo.ID,
+			&photo.FileName,
+			&photo.CreatedAt,
+			&photo.TakenAt,
+			&photo.Latitude,
+			&photo.Longitude,
+			&photo.Event,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		photos = append(photos, &photo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// Returns at most n photos for each event, ordered by event date.
+// If the event has a cover or highlights those are returned (cover first, then highlights in their order),
+// otherwise its first photos ordered by date.
+// Highlights are not removed from events when photos are deleted, so only existing ones are considered
 func (m *PhotoModel) Summary(n int) ([]*Photo, error) {
 	query := `
     SELECT l.id, l.file_name, l.created_at, l.taken_at, l.latitude, l.longitude, l.event
     FROM events AS e, lateral (
-        SELECT * 
+        SELECT *, row_number() OVER (
+            ORDER BY (photos.id IS NOT DISTINCT FROM e.cover) DESC, array_position(e.highlights, photos.id) ASC,
+                taken_at ASC, photos.id ASC
+        ) AS rank
         FROM photos
-        WHERE photos.event = e.id 
-        ORDER BY taken_at ASC, photos.id ASC
+        WHERE photos.event = e.id
+            AND (photos.id = e.cover OR photos.id = ANY(e.highlights)
+                OR NOT EXISTS (
+                    SELECT 1 FROM photos AS h
+                    WHERE h.event = e.id AND (h.id = e.cover OR h.id = ANY(e.highlights))
+                ))
+        ORDER BY rank ASC
         LIMIT $1
     ) as l
-    ORDER BY e.day ASC, taken_at ASC, l.id ASC
+    ORDER BY e.day ASC, e.id ASC, l.rank ASC
     `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -314,3 +525,186 @@ func (m *PhotoModel) Summary(n int) ([]*Photo, error) {
 
 	return photos, nil
 }
+
+// Returns at most n photos of all events taken before (before, beforeID), newest first.
+// The last photo returned can be used as the starting point of the next page.
+// Photos without a date are not part of the timeline
+func (m *PhotoModel) GetTimeline(before time.Time, beforeID int, n int) ([]*Photo, error) {
+	query := `
+    SELECT id, file_name, created_at, taken_at, latitude, longitude, event
+    FROM photos
+    WHERE taken_at IS NOT NULL AND (taken_at, id) < ($1, $2)
+    ORDER BY taken_at DESC, id DESC
+    LIMIT $3`
+	// Paging by (taken_at, id) instead of offset lets postgres walk index_date backwards,
+	// and photos are not skipped or repeated if some are uploaded in the meantime
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before, beforeID, n)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	photos := []*Photo{}
+
+	for rows.Next() {
+		var photo Photo
+
+		err := rows.Scan(
+			&photo.ID,
+			&photo.FileName,
+			&photo.CreatedAt,
+			&photo.TakenAt,
+			&photo.Latitude,
+			&photo.Longitude,
+			&photo.Event,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		photos = append(photos, &photo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// Returns the months that have photos, newest first
+func (m *PhotoModel) GetTimelineMonths() ([]*TimelineMonth, error) {
+	query := `
+    SELECT date_trunc('month', taken_at) AS month, COUNT(*)
+    FROM photos
+    WHERE taken_at IS NOT NULL
+    GROUP BY month
+    ORDER BY month DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	months := []*TimelineMonth{}
+
+	for rows.Next() {
+		var month TimelineMonth
+
+		err := rows.Scan(&month.Month, &month.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		months = append(months, &month)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return months, nil
+}
+
+// Group the geotagged photos inside the bounding box in a grid of cells of cellSize degrees.
+// Each cell with photos becomes a cluster, positioned at the average of its photos' coordinates
+func (m *PhotoModel) GetClusters(bbox BoundingBox, cellSize float64) ([]*PhotoCluster, error) {
+	query := `
+    SELECT COUNT(*), AVG(latitude), AVG(longitude),
+        (array_agg(id ORDER BY taken_at DESC NULLS LAST, id DESC))[1],
+        (array_agg(file_name ORDER BY taken_at DESC NULLS LAST, id DESC))[1],
+        (array_agg(event ORDER BY taken_at DESC NULLS LAST, id DESC))[1]
+    FROM photos
+    WHERE latitude BETWEEN $1 AND $2 AND longitude BETWEEN $3 AND $4
+    GROUP BY floor(latitude / $5), floor(longitude / $5)
+    LIMIT 5000`
+	// The limit is only a safeguard, the cell size should keep the clusters far fewer
+
+	args := []any{bbox.South, bbox.North, bbox.West, bbox.East, cellSize}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	clusters := []*PhotoCluster{}
+
+	for rows.Next() {
+		var cluster PhotoCluster
+		var photo Photo
+
+		err := rows.Scan(
+			&cluster.Count,
+			&cluster.Latitude,
+			&cluster.Longitude,
+			&photo.ID,
+			&photo.FileName,
+			&photo.Event,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		cluster.Photo = &photo
+		clusters = append(clusters, &cluster)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clusters, nil
+}
+
+// Get the users that uploaded photos to the event, ordered by name. Only their id and name are set
+func (m *PhotoModel) GetUploaders(event int) ([]*User, error) {
+	query := `
+    SELECT DISTINCT users.id, users.name
+    FROM photos JOIN users ON photos.uploader = users.id
+    WHERE photos.event = $1
+    ORDER BY users.name ASC, users.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, event)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(&user.ID, &user.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import "slices"
+
+// Roles a user can have, from the one that can do less to the one that can do everything
+const (
+	RoleViewer      = "viewer"
+	RoleContributor = "contributor"
+	RoleEditor      = "editor"
+	RoleAdmin       = "admin"
+)
+
+var Roles = []string{RoleViewer, RoleContributor, RoleEditor, RoleAdmin}
+
+// Actions that require a permission. Viewing events and photos is governed by the
+// event permissions instead, every role can view what it has access to
+const (
+	PermissionPhotosUpload     = "photos:upload"     // Upload photos to the events the user can contribute to
+	PermissionPhotosDelete     = "photos:delete"     // Delete photos and edit their metadata
+	PermissionEventsEdit       = "events:edit"       // Create and update events
+	PermissionEventsDelete     = "events:delete"     // Delete events with all their photos
+	PermissionEventsAccess     = "events:access"     // View and contribute to every event, and manage who can
+	PermissionAlbumsEdit       = "albums:edit"       // Create, update and delete albums
+	PermissionCommentsModerate = "comments:moderate" // Hide and unhide the comments of other users
+	PermissionUsersManage      = "users:manage"      // Create users and groups
+	PermissionSharesManage     = "shares:manage"     // Create and revoke public share links
+	PermissionAuditView        = "audit:view"        // Read the audit log
+	PermissionTrashManage      = "trash:manage"      // Restore and purge deleted photos and events
+)
+
+var rolePermissions = map[string][]string{
+	RoleViewer: {},
+	RoleContributor: {
+		PermissionPhotosUpload,
+	},
+	RoleEditor: {
+		PermissionPhotosUpload,
+		PermissionPhotosDelete,
+		PermissionEventsEdit,
+		PermissionAlbumsEdit,
+		PermissionCommentsModerate,
+	},
+	RoleAdmin: {
+		PermissionPhotosUpload,
+		PermissionPhotosDelete,
+		PermissionEventsEdit,
+		PermissionEventsDelete,
+		PermissionEventsAccess,
+		PermissionAlbumsEdit,
+		PermissionCommentsModerate,
+		PermissionUsersManage,
+		PermissionSharesManage,
+		PermissionAuditView,
+		PermissionTrashManage,
+	},
+}
+
+// Whether the role grants the permission. Unknown roles grant nothing
+func RoleHas(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// How often the last activity of a session is written, so that it is not written at every request
+const sessionSeenInterval = time.Minute
+
+type SessionModelInterface interface {
+	Insert(session *Session) error
+	Seen(id string, user int, ip string) (bool, error)
+	GetForUser(user int) ([]*Session, error)
+	Delete(id string, user int) error
+	DeleteForUser(user int, except string) error
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Metadata of a logged in session. The session data only holds its ID, so
+// deleting it logs the session out without knowing its token
+type Session struct {
+	ID        string
+	UserID    int
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	Expiry    time.Time
+}
+
+func GenerateSession(user int, ip, userAgent string, expiry time.Time) (*Session, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Longer user agents are of no use in the list of sessions
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+
+	return &Session{
+		ID:        base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		UserID:    user,
+		IP:        ip,
+		UserAgent: userAgent,
+		Expiry:    expiry,
+	}, nil
+}
+
+// Insert the session, forgetting the expired ones
+func (m *SessionModel) Insert(session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+    DELETE FROM user_sessions
+    WHERE expiry < NOW()
+    `
+
+	_, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+    INSERT INTO user_sessions (id, user_id, ip, user_agent, expiry)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING created_at, last_seen
+    `
+
+	args := []any{session.ID, session.UserID, session.IP, session.UserAgent, session.Expiry}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&session.CreatedAt, &session.LastSeen)
+}
+
+// Record the activity of the session, false if it was revoked
+func (m *SessionModel) Seen(id string, user int, ip string) (bool, error) {
+	query := `
+    SELECT last_seen
+    FROM user_sessions
+    WHERE id = $1 AND user_id = $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lastSeen time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, id, user).Scan(&lastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if time.Since(lastSeen) < sessionSeenInterval {
+		return true, nil
+	}
+
+	query = `
+    UPDATE user_sessions
+    SET last_seen = NOW(), ip = $2
+    WHERE id = $1
+    `
+
+	_, err = m.DB.ExecContext(ctx, query, id, ip)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Get the sessions of the user that have not expired, most recently active first
+func (m *SessionModel) GetForUser(user int) ([]*Session, error) {
+	query := `
+    SELECT id, user_id, ip, user_agent, created_at, last_seen, expiry
+    FROM user_sessions
+    WHERE user_id = $1 AND expiry > NOW()
+    ORDER BY last_seen DESC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastSeen,
+			&session.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke a session of the user, ErrRecordNotFound if the user has no such session
+func (m *SessionModel) Delete(id string, user int) error {
+	query := `
+    DELETE FROM user_sessions
+    WHERE id = $1 AND user_id = $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id, user)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Revoke all the sessions of the user, except for the one with id except
+func (m *SessionModel) DeleteForUser(user int, except string) error {
+	query := `
+    DELETE FROM user_sessions
+    WHERE user_id = $1 AND id <> $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, user, except)
+	return err
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Keys of the site wide settings
+const (
+	SettingRequireAdminTwoFactor = "require_admin_two_factor"
+)
+
+type SettingModelInterface interface {
+	GetBool(key string) (bool, error)
+	SetBool(key string, value bool) error
+}
+
+type SettingModel struct {
+	DB *sql.DB
+}
+
+// Get a boolean setting, false if it was never set
+func (m *SettingModel) GetBool(key string) (bool, error) {
+	query := `
+    SELECT value
+    FROM settings
+    WHERE key = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value string
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func (m *SettingModel) SetBool(key string, value bool) error {
+	query := `
+    INSERT INTO settings (key, value)
+    VALUES ($1, $2)
+    ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, strconv.FormatBool(value))
+	return err
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"sitoWow/internal/validator"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type ShareLinkModelInterface interface {
+	Insert(link *ShareLink) error
+	GetByToken(plaintext string) (*ShareLink, error)
+	Get(id int) (*ShareLink, error)
+	GetAll() ([]*ShareLink, error)
+	Delete(id int) error
+}
+
+type ShareLinkModel struct {
+	DB *sql.DB
+}
+
+// Public link to an event, or to some of its photos, for people without an account.
+// Like API tokens, only the hash of the token in the url is stored
+type ShareLink struct {
+	ID            int
+	Plaintext     string
+	Hash          []byte
+	Event         int
+	EventName     string
+	Photos        []int // IDs of the shared photos, all the photos of the event are shared if nil
+	CreatedBy     *int
+	CreatedAt     time.Time
+	Expiry        *time.Time // Never expires if nil
+	Password      password   // No password is needed if the hash is nil
+	AllowDownload bool
+}
+
+func (l *ShareLink) HasPassword() bool {
+	return l.Password.hash != nil
+}
+
+func (l *ShareLink) Expired() bool {
+	return l.Expiry != nil && l.Expiry.Before(time.Now())
+}
+
+// Whether the photo is one of the shared ones
+func (l *ShareLink) Includes(photo *Photo) bool {
+	return photo.Event == l.Event && (l.Photos == nil || slices.Contains(l.Photos, photo.ID))
+}
+
+func GenerateShareLink(event int, photos []int, createdBy int, expiry *time.Time, allowDownload bool) (*ShareLink, error) {
+	link := &ShareLink{
+		Event:         event,
+		Photos:        photos,
+		CreatedBy:     &createdBy,
+		Expiry:        expiry,
+		AllowDownload: allowDownload,
+	}
+
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	link.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(link.Plaintext))
+	link.Hash = hash[:]
+
+	return link, nil
+}
+
+func ValidateShareLink(v *validator.Validator, link *ShareLink) {
+	v.CheckField(link.Photos == nil || len(link.Photos) > 0, "photos", "You must select at least one photo")
+	v.CheckField(link.Expiry == nil || link.Expiry.After(time.Now()), "expiry", "Expiry must be in the future")
+
+	if link.Password.plaintext != nil {
+		v.CheckField(validator.CharsCount(*link.Password.plaintext, 8, 72), "password", "Password must be between 8 and 72 characters long")
+	}
+}
+
+func (m *ShareLinkModel) Insert(link *ShareLink) error {
+	query := `
+    INSERT INTO share_links (hash, event, photos, created_by, expiry, password_hash, allow_download)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING id, created_at
+    `
+
+	var photos pq.Int64Array
+	if link.Photos != nil {
+		photos = newInt64s(link.Photos)
+	}
+
+	args := []any{
+		link.Hash,
+		link.Event,
+		photos,
+		newNullInt(link.CreatedBy),
+		newNullTime(link.Expiry),
+		link.Password.hash,
+		link.AllowDownload,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		if err.Error() == `pq: insert or update on table "share_links" violates foreign key constraint "fk_event_id"` {
+			return ErrRecordNotFound
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+const shareLinkColumns = `s.id, s.event, e.name, s.photos, s.created_by, s.created_at, s.expiry, s.password_hash, s.allow_download`
+
+func scanShareLink(row interface{ Scan(...any) error }) (*ShareLink, error) {
+	var link ShareLink
+	var photos pq.Int64Array
+
+	err := row.Scan(
+		&link.ID,
+		&link.Event,
+		&link.EventName,
+		&photos,
+		&link.CreatedBy,
+		&link.CreatedAt,
+		&link.Expiry,
+		&link.Password.hash,
+		&link.AllowDownload,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if photos != nil {
+		link.Photos = newInts(photos)
+	}
+
+	return &link, nil
+}
+
+// Get the link matching the plaintext token, if it has not expired
+func (m *ShareLinkModel) GetByToken(plaintext string) (*ShareLink, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+    SELECT ` + shareLinkColumns + `
+    FROM share_links AS s JOIN events AS e ON e.id = s.event
+    WHERE s.hash = $1 AND (s.expiry IS NULL OR s.expiry > NOW())
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	link, err := scanShareLink(m.DB.QueryRowContext(ctx, query, hash[:]))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// Get the link, if it has not expired
+func (m *ShareLinkModel) Get(id int) (*ShareLink, error) {
+	query := `
+    SELECT ` + shareLinkColumns + `
+    FROM share_links AS s JOIN events AS e ON e.id = s.event
+    WHERE s.id = $1 AND (s.expiry IS NULL OR s.expiry > NOW())
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	link, err := scanShareLink(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// Get all the links, newest first. Expired ones are included
+func (m *ShareLinkModel) GetAll() ([]*ShareLink, error) {
+	query := `
+    SELECT ` + shareLinkColumns + `
+    FROM share_links AS s JOIN events AS e ON e.id = s.event
+    ORDER BY s.created_at DESC, s.id DESC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (m *ShareLinkModel) Delete(id int) error {
+	query := `
+    DELETE FROM share_links
+    WHERE id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
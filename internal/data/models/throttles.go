@@ -0,0 +1,194 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The first failures are free, then every failure doubles the wait before the next attempt,
+// until the key is locked out. Failures are forgotten a day after the last one
+const (
+	LoginFreeFailures    = 3
+	LoginLockoutFailures = 10
+	LoginLockoutDuration = 30 * time.Minute
+	loginFailuresMemory  = 24 * time.Hour
+)
+
+type LoginThrottleModelInterface interface {
+	BlockedUntil(keys ...string) (*time.Time, error)
+	RecordFailure(key string) (*LoginThrottle, error)
+	Reset(keys ...string) error
+	GetForUsers() (map[string]*LoginThrottle, error)
+}
+
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+// Failed logins for a username, an ip address or a share link, whose keys are made by
+// UserThrottleKey, IPThrottleKey and ShareThrottleKey
+type LoginThrottle struct {
+	Key          string
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil *time.Time
+}
+
+func UserThrottleKey(name string) string {
+	return "user:" + name
+}
+
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func ShareThrottleKey(link int) string {
+	return "share:" + strconv.Itoa(link)
+}
+
+func (t *LoginThrottle) Blocked() bool {
+	return t.BlockedUntil != nil && t.BlockedUntil.After(time.Now())
+}
+
+func (t *LoginThrottle) LockedOut() bool {
+	return t.Blocked() && t.Failures >= LoginLockoutFailures
+}
+
+// How long the key is blocked after its nth consecutive failure
+func loginBackoff(failures int) time.Duration {
+	switch {
+	case failures >= LoginLockoutFailures:
+		return LoginLockoutDuration
+	case failures > LoginFreeFailures:
+		return time.Second << (failures - LoginFreeFailures)
+	default:
+		return 0
+	}
+}
+
+// Get the time until which logins are blocked for any of the keys, nil if they are not
+func (m *LoginThrottleModel) BlockedUntil(keys ...string) (*time.Time, error) {
+	query := `
+    SELECT MAX(blocked_until)
+    FROM login_throttles
+    WHERE key = ANY($1) AND blocked_until > NOW()
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var until *time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&until)
+	if err != nil {
+		return nil, err
+	}
+
+	return until, nil
+}
+
+// Count a failed login for the key, and block it according to how many consecutive failures it has
+func (m *LoginThrottleModel) RecordFailure(key string) (*LoginThrottle, error) {
+	query := `
+    INSERT INTO login_throttles (key, failures, last_failure)
+    VALUES ($1, 1, NOW())
+    ON CONFLICT (key) DO UPDATE SET
+        failures = CASE
+            WHEN login_throttles.last_failure < NOW() - $2 * interval '1 second' THEN 1
+            ELSE login_throttles.failures + 1
+        END,
+        last_failure = NOW()
+    RETURNING key, failures, last_failure
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var throttle LoginThrottle
+
+	err := m.DB.QueryRowContext(ctx, query, key, loginFailuresMemory.Seconds()).Scan(
+		&throttle.Key,
+		&throttle.Failures,
+		&throttle.LastFailure,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := loginBackoff(throttle.Failures)
+	if backoff == 0 {
+		return &throttle, nil
+	}
+
+	query = `
+    UPDATE login_throttles
+    SET blocked_until = $2
+    WHERE key = $1
+    `
+
+	until := time.Now().Add(backoff)
+	throttle.BlockedUntil = &until
+
+	_, err = m.DB.ExecContext(ctx, query, key, until)
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+// Forget the failures of the keys, after a successful login or when an admin unlocks a user
+func (m *LoginThrottleModel) Reset(keys ...string) error {
+	query := `
+    DELETE FROM login_throttles
+    WHERE key = ANY($1)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(keys))
+	return err
+}
+
+// Get the recent failures of usernames, by name
+func (m *LoginThrottleModel) GetForUsers() (map[string]*LoginThrottle, error) {
+	query := `
+    SELECT key, failures, last_failure, blocked_until
+    FROM login_throttles
+    WHERE key LIKE 'user:%' AND last_failure >= NOW() - $1 * interval '1 second'
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, loginFailuresMemory.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	throttles := make(map[string]*LoginThrottle)
+
+	for rows.Next() {
+		var t LoginThrottle
+
+		err := rows.Scan(&t.Key, &t.Failures, &t.LastFailure, &t.BlockedUntil)
+		if err != nil {
+			return nil, err
+		}
+
+		throttles[strings.TrimPrefix(t.Key, "user:")] = &t
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return throttles, nil
+}
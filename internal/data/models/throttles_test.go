@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 1, want: 0},
+		{failures: LoginFreeFailures, want: 0},
+		{failures: LoginFreeFailures + 1, want: 2 * time.Second},
+		{failures: LoginFreeFailures + 2, want: 4 * time.Second},
+		{failures: LoginFreeFailures + 3, want: 8 * time.Second},
+		{failures: LoginLockoutFailures - 1, want: 64 * time.Second},
+		{failures: LoginLockoutFailures, want: LoginLockoutDuration},
+		{failures: LoginLockoutFailures + 5, want: LoginLockoutDuration},
+	}
+
+	for _, tt := range tests {
+		got := loginBackoff(tt.failures)
+		if got != tt.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleBlocked(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name      string
+		throttle  LoginThrottle
+		blocked   bool
+		lockedOut bool
+	}{
+		{name: "never blocked", throttle: LoginThrottle{Failures: 2}},
+		{name: "block expired", throttle: LoginThrottle{Failures: 5, BlockedUntil: &past}},
+		{name: "blocked", throttle: LoginThrottle{Failures: 5, BlockedUntil: &future}, blocked: true},
+		{name: "locked out", throttle: LoginThrottle{Failures: LoginLockoutFailures, BlockedUntil: &future}, blocked: true, lockedOut: true},
+		{name: "lockout expired", throttle: LoginThrottle{Failures: LoginLockoutFailures, BlockedUntil: &past}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.throttle.Blocked(); got != tt.blocked {
+				t.Errorf("Blocked() = %v, want %v", got, tt.blocked)
+			}
+			if got := tt.throttle.LockedOut(); got != tt.lockedOut {
+				t.Errorf("LockedOut() = %v, want %v", got, tt.lockedOut)
+			}
+		})
+	}
+}
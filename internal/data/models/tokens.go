@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"sitoWow/internal/validator"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// What an API token can be used for. Admin tokens can do everything
+const (
+	ScopeRead   = "read"
+	ScopeUpload = "upload"
+	ScopeAdmin  = "admin"
+)
+
+var TokenScopes = []string{ScopeRead, ScopeUpload, ScopeAdmin}
+
+// Scopes the tokens of a user with the role can have. The admin scope is only
+// useful to roles that can change more than their own uploads
+func AllowedScopes(role string) []string {
+	if RoleHas(role, PermissionEventsEdit) {
+		return TokenScopes
+	}
+
+	return []string{ScopeRead, ScopeUpload}
+}
+
+type TokenModelInterface interface {
+	Insert(token *Token) error
+	Authenticate(plaintext string) (*Token, error)
+	GetAllForUser(user int) ([]*Token, error)
+	Delete(id int) error
+	DeleteForUser(id, user int) error
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Personal API token. Only the hash is stored, the plaintext is known just after creation
+type Token struct {
+	ID         int
+	Plaintext  string
+	Hash       []byte
+	UserID     int
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	Expiry     *time.Time // Never expires if nil
+	LastUsedAt *time.Time
+}
+
+func (t *Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+func GenerateToken(user int, name string, scopes []string, expiry *time.Time) (*Token, error) {
+	token := &Token{
+		UserID: user,
+		Name:   name,
+		Scopes: scopes,
+		Expiry: expiry,
+	}
+
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func ValidateToken(v *validator.Validator, token *Token) {
+	v.CheckField(validator.NotBlank(token.Name), "name", "This field must not be empty")
+	v.CheckField(validator.CharsCount(token.Name, 0, 500), "name", "Name must be at most 500 characters long")
+	v.CheckField(len(token.Scopes) > 0, "scopes", "You must select at least one scope")
+	for _, s := range token.Scopes {
+		v.CheckField(validator.PermittedValue(s, TokenScopes...), "scopes", "Invalid scope")
+	}
+	v.CheckField(token.Expiry == nil || token.Expiry.After(time.Now()), "expiry", "Expiry must be in the future")
+}
+
+func (m *TokenModel) Insert(token *Token) error {
+	query := `
+    INSERT INTO tokens (hash, user_id, name, scopes, expiry)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING id, created_at
+    `
+
+	args := []any{token.Hash, token.UserID, token.Name, pq.Array(token.Scopes), newNullTime(token.Expiry)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// Get the token matching the plaintext, if it has not expired, and mark it as used
+func (m *TokenModel) Authenticate(plaintext string) (*Token, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+    UPDATE tokens
+    SET last_used_at = NOW()
+    WHERE hash = $1 AND (expiry IS NULL OR expiry > NOW())
+    RETURNING ` + tokenColumns + `
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token, err := scanToken(m.DB.QueryRowContext(ctx, query, hash[:]))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, err
+	}
+
+	return token, nil
+}
+
+const tokenColumns = `id, user_id, name, scopes, created_at, expiry, last_used_at`
+
+func scanToken(row interface{ Scan(...any) error }) (*Token, error) {
+	var token Token
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Get the tokens of a user, newest first. Expired ones are included
+func (m *TokenModel) GetAllForUser(user int) ([]*Token, error) {
+	query := `
+    SELECT ` + tokenColumns + `
+    FROM tokens
+    WHERE user_id = $1
+    ORDER BY created_at DESC, id DESC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m *TokenModel) Delete(id int) error {
+	query := `
+    DELETE FROM tokens
+    WHERE id = $1
+    `
+
+	return m.delete(query, id)
+}
+
+// Delete a token only if it belongs to the user
+func (m *TokenModel) DeleteForUser(id, user int) error {
+	query := `
+    DELETE FROM tokens
+    WHERE id = $1 AND user_id = $2
+    `
+
+	return m.delete(query, id, user)
+}
+
+func (m *TokenModel) delete(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestTokenHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{name: "no scopes", scopes: nil, scope: ScopeRead, want: false},
+		{name: "same scope", scopes: []string{ScopeRead}, scope: ScopeRead, want: true},
+		{name: "other scope", scopes: []string{ScopeRead}, scope: ScopeUpload, want: false},
+		{name: "one of many", scopes: []string{ScopeRead, ScopeUpload}, scope: ScopeUpload, want: true},
+		{name: "admin has read", scopes: []string{ScopeAdmin}, scope: ScopeRead, want: true},
+		{name: "admin has upload", scopes: []string{ScopeAdmin}, scope: ScopeUpload, want: true},
+		{name: "upload is not admin", scopes: []string{ScopeRead, ScopeUpload}, scope: ScopeAdmin, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &Token{Scopes: tt.scopes}
+			if got := token.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%q) with %v = %v, want %v", tt.scope, tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTrashEventMissing = errors.New("event of the photo does not exist")
+
+// Kinds of the items in the trash
+const (
+	TrashKindPhoto = "photo"
+	TrashKindEvent = "event"
+)
+
+type TrashModelInterface interface {
+	TrashPhoto(id int, deletedBy int, moveFiles func(item int) error) (*TrashItem, error)
+	TrashEvent(id int, deletedBy int, moveFiles func(item int) error) (*TrashItem, error)
+	Get(id int) (*TrashItem, error)
+	GetAll() ([]*TrashItem, error)
+	GetExpired(before time.Time) ([]*TrashItem, error)
+	Restore(id int, restoreFiles func() error) error
+	Delete(id int) error
+}
+
+type TrashModel struct {
+	DB *sql.DB
+}
+
+// Deleted photo or event. The rows that are deleted along with it are kept as json, so that
+// restoring it brings back its favourites, comments, album entries, covers, permissions and
+// share links too
+type TrashItem struct {
+	ID            int
+	Kind          string
+	Name          string // File name of the photo or name of the event
+	Event         int    // Event of the photo, or the event itself
+	Photos        int
+	DeletedBy     *int
+	DeletedByName string
+	DeletedAt     time.Time
+}
+
+// Rows of the photos in p, and of the records that are deleted with them
+const trashPhotoData = `
+    'photos', (SELECT COALESCE(jsonb_agg(to_jsonb(p)), '[]') FROM p),
+    'favourites', (SELECT COALESCE(jsonb_agg(to_jsonb(f)), '[]') FROM favourites AS f WHERE f.photo IN (SELECT id FROM p)),
+    'comments', (SELECT COALESCE(jsonb_agg(to_jsonb(c)), '[]') FROM comments AS c WHERE c.photo IN (SELECT id FROM p)),
+    'comment_edits', (
+        SELECT COALESCE(jsonb_agg(to_jsonb(ce)), '[]')
+        FROM comment_edits AS ce
+        WHERE ce.comment IN (SELECT id FROM comments WHERE photo IN (SELECT id FROM p))
+    ),
+    'album_photos', (SELECT COALESCE(jsonb_agg(to_jsonb(ap)), '[]') FROM album_photos AS ap WHERE ap.photo IN (SELECT id FROM p)),
+    'album_covers', (
+        SELECT COALESCE(jsonb_agg(jsonb_build_object('id', a.id, 'cover', a.cover)), '[]')
+        FROM albums AS a
+        WHERE a.cover IN (SELECT id FROM p)
+    ),
+    'event_covers', (
+        SELECT COALESCE(jsonb_agg(jsonb_build_object('id', e.id, 'cover', e.cover)), '[]')
+        FROM events AS e
+        WHERE e.cover IN (SELECT id FROM p)
+    )`
+
+const trashColumns = `id, kind, name, event, photos, deleted_by, deleted_at`
+
+// Copy the photo to the trash and delete it. moveFiles is called with the id of the item before
+// committing, see trash
+func (m *TrashModel) TrashPhoto(id int, deletedBy int, moveFiles func(item int) error) (*TrashItem, error) {
+	query := `
+    WITH p AS (SELECT * FROM photos WHERE id = $1)
+    INSERT INTO trash (kind, name, event, photos, deleted_by, data)
+    SELECT 'photo', photo.file_name, photo.event, 1, $2, jsonb_build_object(` + trashPhotoData + `)
+    FROM p AS photo
+    RETURNING ` + trashColumns
+
+	return m.trash(query, `DELETE FROM photos WHERE id = $1`, id, deletedBy, moveFiles)
+}
+
+// Copy the event with all its photos to the trash and delete it. Its sub events are
+// moved to the top level, and back under it if it is restored. moveFiles is called with the id of
+// the item before committing, see trash
+func (m *TrashModel) TrashEvent(id int, deletedBy int, moveFiles func(item int) error) (*TrashItem, error) {
+	query := `
+    WITH p AS (SELECT * FROM photos WHERE event = $1)
+    INSERT INTO trash (kind, name, event, photos, deleted_by, data)
+    SELECT 'event', e.name, e.id, (SELECT count(*) FROM p), $2, jsonb_build_object(
+        'events', jsonb_build_array(to_jsonb(e)),
+        'children', (SELECT COALESCE(jsonb_agg(c.id), '[]') FROM events AS c WHERE c.parent = e.id),
+        'event_permissions', (SELECT COALESCE(jsonb_agg(to_jsonb(ep)), '[]') FROM event_permissions AS ep WHERE ep.event = e.id),
+        'share_links', (SELECT COALESCE(jsonb_agg(to_jsonb(sl)), '[]') FROM share_links AS sl WHERE sl.event = e.id),` + trashPhotoData + `)
+    FROM events AS e
+    WHERE e.id = $1
+    RETURNING ` + trashColumns
+
+	return m.trash(query, `DELETE FROM events WHERE id = $1`, id, deletedBy, moveFiles)
+}
+
+// Run the insert into the trash and the delete of the record in a single transaction. moveFiles is
+// called once both are done, and the transaction is only committed if it succeeds, so that the
+// records are never in the trash without their files
+func (m *TrashModel) trash(insertQuery, deleteQuery string, id int, deletedBy int, moveFiles func(item int) error) (*TrashItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var item TrashItem
+
+	// Deleted by nobody if 0
+	deletedByArg := sql.NullInt64{Int64: int64(deletedBy), Valid: deletedBy != 0}
+
+	err = tx.QueryRowContext(ctx, insertQuery, id, deletedByArg).Scan(
+		&item.ID,
+		&item.Kind,
+		&item.Name,
+		&item.Event,
+		&item.Photos,
+		&item.DeletedBy,
+		&item.DeletedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, deleteQuery, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = moveFiles(item.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, tx.Commit()
+}
+
+func scanTrashItem(row interface{ Scan(...any) error }) (*TrashItem, error) {
+	var item TrashItem
+
+	err := row.Scan(
+		&item.ID,
+		&item.Kind,
+		&item.Name,
+		&item.Event,
+		&item.Photos,
+		&item.DeletedBy,
+		&item.DeletedByName,
+		&item.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+const trashItemQuery = `
+    SELECT t.id, t.kind, t.name, t.event, t.photos, t.deleted_by, COALESCE(u.name, ''), t.deleted_at
+    FROM trash AS t
+    LEFT JOIN users AS u ON u.id = t.deleted_by`
+
+func (m *TrashModel) Get(id int) (*TrashItem, error) {
+	query := trashItemQuery + `
+    WHERE t.id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	item, err := scanTrashItem(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (m *TrashModel) query(query string, args ...any) ([]*TrashItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*TrashItem{}
+
+	for rows.Next() {
+		item, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Get all the items in the trash, most recently deleted first
+func (m *TrashModel) GetAll() ([]*TrashItem, error) {
+	return m.query(trashItemQuery + `
+    ORDER BY t.deleted_at DESC, t.id DESC
+    `)
+}
+
+// Get the items deleted before the time, to purge them
+func (m *TrashModel) GetExpired(before time.Time) ([]*TrashItem, error) {
+	return m.query(trashItemQuery+`
+    WHERE t.deleted_at < $1
+    ORDER BY t.deleted_at ASC
+    `, before)
+}
+
+// Put the records of the item back and remove it from the trash, in a single transaction.
+// Records of users, groups and albums that were deleted in the meantime are left out, and
+// covers chosen in the meantime are kept. restoreFiles is called once all the records are
+// inserted, and the transaction is only committed if it succeeds. Returns
+// ErrTrashEventMissing if the item is a photo whose event does not exist anymore
+func (m *TrashModel) Restore(id int, restoreFiles func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var kind string
+	var event int
+	var data []byte
+
+	query := `
+    SELECT kind, event, data
+    FROM trash
+    WHERE id = $1
+    FOR UPDATE
+    `
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&kind, &event, &data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+
+		return err
+	}
+
+	var queries []string
+
+	if kind == TrashKindEvent {
+		// Covers and parents are set once the photos and the other events exist
+		queries = append(queries, `
+        INSERT INTO events
+        SELECT * FROM jsonb_populate_recordset(NULL::events, (
+            SELECT jsonb_agg(e - 'cover' - 'parent') FROM jsonb_array_elements($1::jsonb->'events') AS e
+        ))
+        `)
+	} else {
+		var exists bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM events WHERE id = $1)`, event).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrTrashEventMissing
+		}
+	}
+
+	queries = append(queries, `
+    INSERT INTO photos
+    SELECT * FROM jsonb_populate_recordset(NULL::photos, (
+        SELECT COALESCE(jsonb_agg(
+            CASE WHEN (p->>'uploader')::bigint IN (SELECT id FROM users) THEN p ELSE p - 'uploader' END
+        ), '[]')
+        FROM jsonb_array_elements($1::jsonb->'photos') AS p
+    ))
+    `, `
+    INSERT INTO favourites
+    SELECT * FROM jsonb_populate_recordset(NULL::favourites, $1::jsonb->'favourites')
+    WHERE user_id IN (SELECT id FROM users)
+    `, `
+    WITH RECURSIVE c AS (
+        SELECT * FROM jsonb_populate_recordset(NULL::comments, $1::jsonb->'comments')
+    ), kept AS (
+        SELECT * FROM c WHERE c.parent IS NULL AND c.author IN (SELECT id FROM users)
+        UNION ALL
+        SELECT c.* FROM c JOIN kept ON c.parent = kept.id WHERE c.author IN (SELECT id FROM users)
+    )
+    INSERT INTO comments
+    SELECT * FROM kept
+    `, `
+    INSERT INTO comment_edits
+    SELECT * FROM jsonb_populate_recordset(NULL::comment_edits, $1::jsonb->'comment_edits')
+    WHERE comment IN (SELECT id FROM comments)
+    `, `
+    INSERT INTO album_photos
+    SELECT * FROM jsonb_populate_recordset(NULL::album_photos, $1::jsonb->'album_photos')
+    WHERE album IN (SELECT id FROM albums)
+    ON CONFLICT DO NOTHING
+    `, `
+    UPDATE albums AS a
+    SET cover = old.cover, version = a.version + 1
+    FROM jsonb_to_recordset($1::jsonb->'album_covers') AS old(id int, cover bigint)
+    WHERE a.id = old.id AND a.cover IS NULL
+    `, `
+    UPDATE events AS e
+    SET cover = old.cover
+    FROM jsonb_to_recordset($1::jsonb->'event_covers') AS old(id int, cover bigint)
+    WHERE e.id = old.id AND e.cover IS NULL
+    `)
+
+	if kind == TrashKindEvent {
+		queries = append(queries, `
+        UPDATE events AS e
+        SET cover = old.cover, parent = (SELECT id FROM events WHERE id = old.parent)
+        FROM jsonb_populate_recordset(NULL::events, $1::jsonb->'events') AS old
+        WHERE e.id = old.id
+        `, `
+        UPDATE events
+        SET parent = ($1::jsonb->'events'->0->>'id')::int
+        WHERE parent IS NULL AND id IN (SELECT jsonb_array_elements_text($1::jsonb->'children')::int)
+        `, `
+        INSERT INTO event_permissions
+        SELECT * FROM jsonb_populate_recordset(NULL::event_permissions, $1::jsonb->'event_permissions')
+        WHERE (user_id IS NULL OR user_id IN (SELECT id FROM users))
+        AND (group_id IS NULL OR group_id IN (SELECT id FROM groups))
+        `, `
+        INSERT INTO share_links
+        SELECT * FROM jsonb_populate_recordset(NULL::share_links, (
+            SELECT COALESCE(jsonb_agg(
+                CASE WHEN (sl->>'created_by')::bigint IN (SELECT id FROM users) THEN sl ELSE sl - 'created_by' END
+            ), '[]')
+            FROM jsonb_array_elements($1::jsonb->'share_links') AS sl
+        ))
+        `)
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, data)
+		if err != nil {
+			if err.Error() == `pq: un valore chiave duplicato viola il vincolo univoco "photos_file_name_key"` ||
+				err.Error() == `pq: duplicate key value violates unique constraint "photos_file_name_key"` {
+				return ErrDuplicateName
+			}
+
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM trash WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = restoreFiles()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge the item, its files must be deleted separately
+func (m *TrashModel) Delete(id int) error {
+	query := `
+    DELETE FROM trash
+    WHERE id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer        = "SitoWow"
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+type TwoFactorModelInterface interface {
+	Enable(tf *TwoFactor) error
+	Get(user int) (*TwoFactor, error)
+	Disable(user int) error
+	UseStep(user int, step int64) (bool, error)
+	UseRecoveryCode(user int, code string) (bool, error)
+	GetEnabledUsers() (map[int]bool, error)
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// TOTP (RFC 6238) second factor of a user. Recovery codes can each be used once instead of a TOTP code,
+// only their hashes are stored and the plaintexts are known just after generation
+type TwoFactor struct {
+	UserID        int
+	Secret        string
+	URL           string // otpauth:// url shown as a QR code during enrolment
+	RecoveryCodes []string
+	CreatedAt     time.Time
+}
+
+// Generate a new secret for the user, which is enabled only after they have proven to have it
+func GenerateTwoFactor(user *User) (*TwoFactor, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Name,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactor{
+		UserID: user.ID,
+		Secret: key.Secret(),
+		URL:    key.URL(),
+	}, nil
+}
+
+// Set new recovery codes, replacing the previous ones when the two factor is enabled
+func (tf *TwoFactor) GenerateRecoveryCodes() error {
+	tf.RecoveryCodes = make([]string, recoveryCodeCount)
+
+	for i := range tf.RecoveryCodes {
+		randomBytes := make([]byte, 10)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+		tf.RecoveryCodes[i] = code[:8] + "-" + code[8:]
+	}
+
+	return nil
+}
+
+// Check the TOTP code, allowing for one period of clock drift. It returns the time step the code is for,
+// which must be passed to UseStep so that the code cannot be used again
+func (tf *TwoFactor) Check(code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+
+	for skew := -1; skew <= 1; skew++ {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+
+		expected, err := totp.GenerateCodeCustom(tf.Secret, t, totpOpts)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return t.Unix() / totpPeriod, true
+		}
+	}
+
+	return 0, false
+}
+
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hash[:]
+}
+
+// Enable the two factor, or replace the secret and the recovery codes if it already was
+func (m *TwoFactorModel) Enable(tf *TwoFactor) error {
+	query := `
+    INSERT INTO two_factor (user_id, secret, recovery_codes)
+    VALUES ($1, $2, $3)
+    ON CONFLICT (user_id) DO UPDATE SET
+        secret = EXCLUDED.secret, recovery_codes = EXCLUDED.recovery_codes, last_step = 0, created_at = NOW()
+    RETURNING created_at
+    `
+
+	hashes := make([][]byte, len(tf.RecoveryCodes))
+	for i, code := range tf.RecoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, tf.UserID, tf.Secret, pq.ByteaArray(hashes)).Scan(&tf.CreatedAt)
+}
+
+// Get the two factor of the user, ErrRecordNotFound if they have not enabled it.
+// RecoveryCodes is not set, since only their hashes are stored
+func (m *TwoFactorModel) Get(user int) (*TwoFactor, error) {
+	query := `
+    SELECT user_id, secret, created_at
+    FROM two_factor
+    WHERE user_id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tf TwoFactor
+
+	err := m.DB.QueryRowContext(ctx, query, user).Scan(&tf.UserID, &tf.Secret, &tf.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return &tf, nil
+}
+
+func (m *TwoFactorModel) Disable(user int) error {
+	query := `
+    DELETE FROM two_factor
+    WHERE user_id = $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, user)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Mark the time step as used, false if a code for it (or a later one) was already used
+func (m *TwoFactorModel) UseStep(user int, step int64) (bool, error) {
+	query := `
+    UPDATE two_factor
+    SET last_step = $2
+    WHERE user_id = $1 AND last_step < $2
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, user, step)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count == 1, nil
+}
+
+// Remove the recovery code, false if the user does not have it
+func (m *TwoFactorModel) UseRecoveryCode(user int, code string) (bool, error) {
+	query := `
+    UPDATE two_factor
+    SET recovery_codes = array_remove(recovery_codes, $2)
+    WHERE user_id = $1 AND $2 = ANY(recovery_codes)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, user, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return count == 1, nil
+}
+
+// Get the ids of the users that have enabled the two factor
+func (m *TwoFactorModel) GetEnabledUsers() (map[int]bool, error) {
+	query := `
+    SELECT user_id
+    FROM two_factor
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[int]bool)
+
+	for rows.Next() {
+		var id int
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		users[id] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
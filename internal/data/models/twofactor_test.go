@@ -0,0 +1,66 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestTwoFactorCheck(t *testing.T) {
+	tf := &TwoFactor{Secret: "JBSWY3DPEHPK3PXP"}
+
+	// In the middle of a period, so that small offsets stay in it
+	now := time.Date(2024, time.May, 1, 12, 0, 15, 0, time.UTC)
+	step := now.Unix() / totpPeriod
+
+	code := func(t *testing.T, at time.Time) string {
+		c, err := totp.GenerateCodeCustom(tf.Secret, at, totpOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		codeAt   time.Duration // When the code was generated, relative to now
+		checkAt  time.Duration // When the code is checked, relative to now
+		code     string        // Used instead of the generated code if set
+		padded   bool          // Surround the code with spaces
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current code", wantStep: step, wantOK: true},
+		{name: "with spaces", padded: true, wantStep: step, wantOK: true},
+		{name: "previous period", codeAt: -totpPeriod * time.Second, wantStep: step - 1, wantOK: true},
+		{name: "next period", codeAt: totpPeriod * time.Second, wantStep: step + 1, wantOK: true},
+		{name: "too old", codeAt: -2 * totpPeriod * time.Second},
+		{name: "too far ahead", codeAt: 2 * totpPeriod * time.Second},
+		{name: "wrong code", code: "000000"},
+		// A code used again gives the step it was first used for, which UseStep refuses
+		{name: "reused in the same period", checkAt: 10 * time.Second, wantStep: step, wantOK: true},
+		{name: "reused in the next period", checkAt: totpPeriod * time.Second, wantStep: step, wantOK: true},
+		{name: "reused after the drift window", checkAt: 2 * totpPeriod * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := code(t, now.Add(tt.codeAt))
+			if tt.code != "" {
+				c = tt.code
+			}
+			if tt.padded {
+				c = " " + c + " "
+			}
+
+			gotStep, gotOK := tf.Check(c, now.Add(tt.checkAt))
+			if gotOK != tt.wantOK {
+				t.Fatalf("Check() ok = %v, want %v", gotOK, tt.wantOK)
+			}
+			if gotOK && gotStep != tt.wantStep {
+				t.Errorf("Check() step = %d, want %d", gotStep, tt.wantStep)
+			}
+		})
+	}
+}
@@ -14,8 +14,11 @@ type UserModelInterface interface {
 	Insert(user *User) error
 	Authenticate(name, password string) (int, error)
 	GetById(id int) (*User, error)
-	Exists(id int) (bool, int, error)
+	GetByName(name string) (*User, error)
+	Exists(id int) (bool, string, error)
 	Update(user *User) error
+	GetAll() ([]*User, error)
+	Delete(id int) error
 }
 
 type UserModel struct {
@@ -26,7 +29,8 @@ type User struct {
 	ID        int
 	Name      string
 	Password  password
-	Level     int
+	Role      string
+	Active    bool // Deactivated users cannot log in
 	CreatedAt time.Time
 	Version   int
 }
@@ -48,10 +52,23 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.CheckField(validator.NotBlank(user.Name), "name", "This field cannot be blank")
 	v.CheckField(validator.CharsCount(user.Name, 0, 500), "name", "Username must be at most 500 characters long")
-	v.CheckField(user.Level >= 0 && user.Level <= 10, "level", "Level must be between 0 and 10")
+	v.CheckField(validator.PermittedValue(user.Role, Roles...), "role", "Invalid role")
 
 	if user.Password.plaintext != nil {
 		v.CheckField(validator.NotBlank(*user.Password.plaintext), "password", "This field must not be blank")
@@ -66,17 +83,17 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 func (m *UserModel) Insert(user *User) error {
 	query := `
-    INSERT INTO users (name, password_hash, level)
+    INSERT INTO users (name, password_hash, role)
     VALUES ($1, $2, $3)
-    RETURNING id, created_at, level, version
+    RETURNING id, created_at, role, active, version
     `
 
-	args := []any{user.Name, user.Password.hash, user.Level}
+	args := []any{user.Name, user.Password.hash, user.Role}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Level, &user.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Role, &user.Active, &user.Version)
 	if err != nil {
 		if err.Error() == `pq: un valore chiave duplicato viola il vincolo univoco "users_name_key"` ||
 			err.Error() == `pq: duplicate key value violates unique constraint "users_name_key"` {
@@ -94,7 +111,7 @@ func (m *UserModel) Authenticate(name, password string) (int, error) {
 	var hashedPassword []byte
 
 	query := `
-    SELECT id, password_hash FROM users WHERE name = $1
+    SELECT id, password_hash FROM users WHERE name = $1 AND active
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -123,7 +140,7 @@ func (m *UserModel) Authenticate(name, password string) (int, error) {
 
 func (m *UserModel) GetById(id int) (*User, error) {
 	query := `
-    SELECT id, created_at, name, password_hash, level, version
+    SELECT id, created_at, name, password_hash, role, active, version
     FROM users
     WHERE id = $1
     `
@@ -138,7 +155,8 @@ func (m *UserModel) GetById(id int) (*User, error) {
 		&user.CreatedAt,
 		&user.Name,
 		&user.Password.hash,
-		&user.Level,
+		&user.Role,
+		&user.Active,
 		&user.Version,
 	)
 	if err != nil {
@@ -152,31 +170,109 @@ func (m *UserModel) GetById(id int) (*User, error) {
 	return &user, nil
 }
 
-func (m *UserModel) Exists(id int) (bool, int, error) {
+func (m *UserModel) GetByName(name string) (*User, error) {
+	query := `
+    SELECT id, created_at, name, password_hash, role, active, version
+    FROM users
+    WHERE name = $1
+    `
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Password.hash,
+		&user.Role,
+		&user.Active,
+		&user.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Whether the user exists and is active, and its role
+func (m *UserModel) Exists(id int) (bool, string, error) {
 	user, err := m.GetById(id)
 	if err != nil {
 		if errors.Is(err, ErrRecordNotFound) {
-			return false, 0, nil
+			return false, "", nil
 		}
 
-		return false, 0, err
+		return false, "", err
+	}
+
+	return user.Active, user.Role, nil
+}
+
+// Matches the user if it is the only active admin left, who cannot be deleted, demoted or deactivated
+const lastAdminCondition = `
+    role = 'admin' AND active AND NOT EXISTS (
+        SELECT 1 FROM users AS other
+        WHERE other.id <> users.id AND other.role = 'admin' AND other.active
+    )`
+
+// Lock the rows of the active admins until the end of the transaction, so that two admins cannot
+// be deleted, demoted or deactivated at the same time, each one seeing the other still there
+func lockAdmins(ctx context.Context, tx *sql.Tx) error {
+	query := `
+    SELECT id
+    FROM users
+    WHERE role = 'admin' AND active
+    FOR UPDATE
+    `
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+func isLastAdmin(ctx context.Context, tx *sql.Tx, id int) (bool, error) {
+	query := `
+    SELECT ` + lastAdminCondition + `
+    FROM users
+    WHERE id = $1
+    `
+
+	var lastAdmin bool
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(&lastAdmin)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
 	}
 
-	return true, user.Level, nil
+	return lastAdmin, nil
 }
 
+// Returns ErrLastAdmin if the user is the last active admin and would not be one anymore
 func (m *UserModel) Update(user *User) error {
 	query := `
     UPDATE users
-    SET name = $1, password_hash = $2, level = $3, version = version +1
-    WHERE id = $4 AND version = $5
+    SET name = $1, password_hash = $2, role = $3, active = $4, version = version +1
+    WHERE id = $5 AND version = $6
+        AND (($3 = 'admin' AND $4) OR NOT (` + lastAdminCondition + `))
     RETURNING version
     `
 
 	args := []any{
 		user.Name,
 		user.Password.hash,
-		user.Level,
+		user.Role,
+		user.Active,
 		user.ID,
 		user.Version,
 	}
@@ -184,18 +280,130 @@ func (m *UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockAdmins(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: un valore chiave duplicato viola il vincolo univoco "users_name_key"` ||
 			err.Error() == `pq: duplicate key value violates unique constraint "users_name_key"`:
 			return ErrDuplicateName
 		case errors.Is(err, sql.ErrNoRows):
+			if user.Role != RoleAdmin || !user.Active {
+				lastAdmin, err := isLastAdmin(ctx, tx, user.ID)
+				if err != nil {
+					return err
+				}
+				if lastAdmin {
+					return ErrLastAdmin
+				}
+			}
+
 			return ErrEditConflict
 		default:
 			return err
 		}
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// Delete the user with its favourites, comments and tokens. Uploaded photos are kept.
+// Returns ErrLastAdmin if the user is the last active admin
+func (m *UserModel) Delete(id int) error {
+	query := `
+    DELETE FROM users
+    WHERE id = $1 AND NOT (` + lastAdminCondition + `)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockAdmins(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		lastAdmin, err := isLastAdmin(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if lastAdmin {
+			return ErrLastAdmin
+		}
+
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Get all users ordered by name
+func (m *UserModel) GetAll() ([]*User, error) {
+	query := `
+    SELECT id, created_at, name, password_hash, role, active, version
+    FROM users
+    ORDER BY name ASC
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Password.hash,
+			&user.Role,
+			&user.Active,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
 }
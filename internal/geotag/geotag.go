@@ -0,0 +1,236 @@
+// Package geotag reads GPS tracks and finds where they were at a given time
+package geotag
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedFormat = errors.New("geotag: track must be a .gpx or .kml file")
+var ErrEmptyTrack = errors.New("geotag: the track has no timed points")
+
+type Point struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+}
+
+// Points of a track, ordered by time
+type Track []Point
+
+// Parse a GPX or KML track, choosing the format from the file name extension
+func Parse(fileName string, r io.Reader) (Track, error) {
+	var track Track
+	var err error
+
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".gpx":
+		track, err = ParseGPX(r)
+	case ".kml":
+		track, err = ParseKML(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(track) == 0 {
+		return nil, ErrEmptyTrack
+	}
+
+	sort.SliceStable(track, func(i, j int) bool { return track[i].Time.Before(track[j].Time) })
+
+	return track, nil
+}
+
+// Track points of all the tracks and segments of a GPX file. Points without time are skipped
+func ParseGPX(r io.Reader) (Track, error) {
+	var gpx struct {
+		Tracks []struct {
+			Segments []struct {
+				Points []struct {
+					Latitude  float64 `xml:"lat,attr"`
+					Longitude float64 `xml:"lon,attr"`
+					Time      string  `xml:"time"`
+				} `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}
+
+	err := xml.NewDecoder(r).Decode(&gpx)
+	if err != nil {
+		return nil, fmt.Errorf("geotag: invalid gpx file: %w", err)
+	}
+
+	track := Track{}
+	for _, t := range gpx.Tracks {
+		for _, s := range t.Segments {
+			for _, p := range s.Points {
+				if p.Time == "" {
+					continue
+				}
+
+				when, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+				if err != nil {
+					return nil, fmt.Errorf("geotag: invalid gpx time %q", p.Time)
+				}
+
+				track = append(track, Point{Time: when, Latitude: p.Latitude, Longitude: p.Longitude})
+			}
+		}
+	}
+
+	return track, nil
+}
+
+// Points of the gx:Track elements of a KML file, and of the placemarks that have both
+// a timestamp and a point
+func ParseKML(r io.Reader) (Track, error) {
+	dec := xml.NewDecoder(r)
+
+	track := Track{}
+	stack := []string{}
+	var text strings.Builder
+
+	// gx:Track has a list of times followed by a list of coordinates
+	var whens, coords []string
+	// Placemark time and coordinates
+	var placemarkWhen, placemarkCoords string
+
+	parent := func() string {
+		if len(stack) < 2 {
+			return ""
+		}
+		return stack[len(stack)-2]
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("geotag: invalid kml file: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+
+			switch {
+			case t.Name.Local == "when" && parent() == "Track":
+				whens = append(whens, value)
+			case t.Name.Local == "coord" && parent() == "Track":
+				coords = append(coords, value)
+			case t.Name.Local == "when" && parent() == "TimeStamp":
+				placemarkWhen = value
+			case t.Name.Local == "coordinates" && parent() == "Point":
+				placemarkCoords = value
+
+			case t.Name.Local == "Track":
+				if len(whens) != len(coords) {
+					return nil, errors.New("geotag: kml track has a different number of times and coordinates")
+				}
+
+				for i := range whens {
+					p, err := kmlPoint(whens[i], strings.Fields(coords[i]))
+					if err != nil {
+						return nil, err
+					}
+					track = append(track, p)
+				}
+				whens, coords = nil, nil
+
+			case t.Name.Local == "Placemark":
+				if placemarkWhen != "" && placemarkCoords != "" {
+					p, err := kmlPoint(placemarkWhen, strings.Split(placemarkCoords, ","))
+					if err != nil {
+						return nil, err
+					}
+					track = append(track, p)
+				}
+				placemarkWhen, placemarkCoords = "", ""
+			}
+
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+
+	return track, nil
+}
+
+// KML coordinates are longitude first, then latitude and optionally altitude
+func kmlPoint(when string, coords []string) (Point, error) {
+	t, err := time.Parse(time.RFC3339, when)
+	if err != nil {
+		return Point{}, fmt.Errorf("geotag: invalid kml time %q", when)
+	}
+
+	if len(coords) < 2 {
+		return Point{}, fmt.Errorf("geotag: invalid kml coordinates %q", coords)
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(coords[0]), 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("geotag: invalid kml longitude %q", coords[0])
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(coords[1]), 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("geotag: invalid kml latitude %q", coords[1])
+	}
+
+	return Point{Time: t, Latitude: lat, Longitude: lon}, nil
+}
+
+// Find where the track was at time t, interpolating linearly between the two closest points.
+// Times outside the track, or between points more than maxGap apart (the device was probably off),
+// have no location
+func (track Track) Locate(t time.Time, maxGap time.Duration) (Point, bool) {
+	// First point not before t
+	i := sort.Search(len(track), func(i int) bool { return !track[i].Time.Before(t) })
+
+	if i == len(track) {
+		return Point{}, false
+	}
+
+	next := track[i]
+	if next.Time.Equal(t) {
+		return next, true
+	}
+
+	if i == 0 {
+		return Point{}, false
+	}
+
+	prev := track[i-1]
+	gap := next.Time.Sub(prev.Time)
+	if gap > maxGap {
+		return Point{}, false
+	}
+
+	ratio := float64(t.Sub(prev.Time)) / float64(gap)
+
+	return Point{
+		Time:      t,
+		Latitude:  prev.Latitude + (next.Latitude-prev.Latitude)*ratio,
+		Longitude: prev.Longitude + (next.Longitude-prev.Longitude)*ratio,
+	}, true
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS favourites (
+    user_id bigint NOT NULL,
+    photo bigint NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, photo),
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_photo_id FOREIGN KEY(photo) REFERENCES photos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS index_favourites_photo ON favourites (photo);
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    photo bigint NOT NULL,
+    parent bigint,
+    author bigint NOT NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    edited_at timestamp(0) with time zone,
+    hidden boolean NOT NULL DEFAULT false,
+    deleted boolean NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT fk_photo_id FOREIGN KEY(photo) REFERENCES photos(id) ON DELETE CASCADE,
+    CONSTRAINT fk_parent_id FOREIGN KEY(parent) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT fk_author_id FOREIGN KEY(author) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS index_comments_photo ON comments (photo);
+
+CREATE TABLE IF NOT EXISTS comment_edits (
+    id bigserial PRIMARY KEY,
+    comment bigint NOT NULL,
+    body text NOT NULL,
+    edited_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_comment_id FOREIGN KEY(comment) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS index_comment_edits_comment ON comment_edits (comment);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS albums (
+    id serial PRIMARY KEY,
+    name text NOT NULL,
+    cover bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT fk_cover_id FOREIGN KEY(cover) REFERENCES photos(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS album_photos (
+    album int NOT NULL,
+    photo bigint NOT NULL,
+    position int NOT NULL,
+    PRIMARY KEY (album, photo),
+    CONSTRAINT fk_album_id FOREIGN KEY(album) REFERENCES albums(id) ON DELETE CASCADE,
+    CONSTRAINT fk_photo_id FOREIGN KEY(photo) REFERENCES photos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS index_album_position ON album_photos (album, position);
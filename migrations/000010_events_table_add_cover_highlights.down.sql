@@ -0,0 +1,4 @@
+ALTER TABLE events
+    DROP CONSTRAINT IF EXISTS fk_cover_id,
+    DROP COLUMN IF EXISTS highlights,
+    DROP COLUMN IF EXISTS cover;
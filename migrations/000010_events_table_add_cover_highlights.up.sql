@@ -0,0 +1,4 @@
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS cover bigint,
+    ADD COLUMN IF NOT EXISTS highlights bigint[] NOT NULL DEFAULT '{}',
+    ADD CONSTRAINT fk_cover_id FOREIGN KEY(cover) REFERENCES photos(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+ALTER TABLE events
+    DROP CONSTRAINT IF EXISTS valid_event_days,
+    DROP CONSTRAINT IF EXISTS valid_event_coords,
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS latitude,
+    DROP COLUMN IF EXISTS location,
+    DROP COLUMN IF EXISTS end_day,
+    DROP COLUMN IF EXISTS description;
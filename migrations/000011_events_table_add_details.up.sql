@@ -0,0 +1,8 @@
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS end_day date,
+    ADD COLUMN IF NOT EXISTS location text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS latitude float CHECK (latitude between -90 and 90),
+    ADD COLUMN IF NOT EXISTS longitude float CHECK (longitude between -180 and 180),
+    ADD CONSTRAINT valid_event_coords CHECK ((latitude is not null and longitude is not null) or (latitude is null and longitude is null)),
+    ADD CONSTRAINT valid_event_days CHECK (end_day is null or (day is not null and end_day >= day));
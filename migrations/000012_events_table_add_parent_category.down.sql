@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS index_events_parent;
+
+ALTER TABLE events
+    DROP CONSTRAINT IF EXISTS valid_parent,
+    DROP CONSTRAINT IF EXISTS fk_parent_id,
+    DROP COLUMN IF EXISTS category,
+    DROP COLUMN IF EXISTS parent;
@@ -0,0 +1,7 @@
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS parent int,
+    ADD COLUMN IF NOT EXISTS category text NOT NULL DEFAULT '',
+    ADD CONSTRAINT fk_parent_id FOREIGN KEY(parent) REFERENCES events(id) ON DELETE SET NULL,
+    ADD CONSTRAINT valid_parent CHECK (parent <> id);
+
+CREATE INDEX IF NOT EXISTS index_events_parent ON events (parent);
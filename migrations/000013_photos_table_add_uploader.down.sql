@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS index_photos_uploader;
+
+ALTER TABLE photos
+    DROP CONSTRAINT IF EXISTS fk_uploader_id,
+    DROP COLUMN IF EXISTS uploader;
@@ -0,0 +1,5 @@
+ALTER TABLE photos
+    ADD COLUMN IF NOT EXISTS uploader int,
+    ADD CONSTRAINT fk_uploader_id FOREIGN KEY(uploader) REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS index_photos_uploader ON photos (uploader);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    id serial PRIMARY KEY,
+    hash bytea NOT NULL UNIQUE,
+    user_id bigint NOT NULL,
+    name text NOT NULL,
+    scopes text[] NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone,
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS index_tokens_user ON tokens (user_id);
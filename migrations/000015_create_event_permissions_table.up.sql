@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS groups (
+    id serial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id int NOT NULL,
+    user_id bigint NOT NULL,
+    PRIMARY KEY (group_id, user_id),
+    CONSTRAINT fk_group_id FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS index_group_members_user ON group_members (user_id);
+
+-- Each row grants either a user or a group access to an event
+CREATE TABLE IF NOT EXISTS event_permissions (
+    id serial PRIMARY KEY,
+    event int NOT NULL,
+    user_id bigint,
+    group_id int,
+    access text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_event_id FOREIGN KEY(event) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_group_id FOREIGN KEY(group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    CONSTRAINT event_permissions_access_check CHECK (access IN ('view', 'contribute')),
+    CONSTRAINT event_permissions_grantee_check CHECK ((user_id IS NULL) <> (group_id IS NULL)),
+    CONSTRAINT event_permissions_user_key UNIQUE (event, user_id),
+    CONSTRAINT event_permissions_group_key UNIQUE (event, group_id)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS level smallint NOT NULL DEFAULT 0;
+
+UPDATE users SET level = 10 WHERE role = 'admin';
+
+ALTER TABLE users DROP COLUMN IF EXISTS role;
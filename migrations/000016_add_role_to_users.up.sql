@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'viewer';
+
+-- Admins keep managing everything, every other level could only view
+UPDATE users SET role = 'admin' WHERE level >= 10;
+
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('viewer', 'contributor', 'editor', 'admin'));
+ALTER TABLE users DROP COLUMN IF EXISTS level;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS share_links (
+    id serial PRIMARY KEY,
+    hash bytea NOT NULL UNIQUE,
+    event int NOT NULL,
+    photos bigint[], -- NULL shares all the photos of the event
+    created_by bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone,
+    password_hash bytea,
+    allow_download boolean NOT NULL DEFAULT false,
+    CONSTRAINT fk_event_id FOREIGN KEY(event) REFERENCES events(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_id FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+);
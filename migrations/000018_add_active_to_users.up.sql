@@ -0,0 +1,2 @@
+-- Deactivated users cannot log in, but their photos and comments are kept
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active boolean NOT NULL DEFAULT true;
@@ -0,0 +1,7 @@
+-- Consecutive failed logins for each username ("user:<name>") and ip address ("ip:<address>")
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key text PRIMARY KEY,
+    failures int NOT NULL DEFAULT 0,
+    last_failure timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    blocked_until timestamp(0) with time zone
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id bigint PRIMARY KEY,
+    secret text NOT NULL,
+    recovery_codes bytea[] NOT NULL, -- sha256 hashes of the unused recovery codes
+    last_step bigint NOT NULL DEFAULT 0, -- time step of the last code used, codes cannot be used twice
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Site wide settings changed by admins
+CREATE TABLE IF NOT EXISTS settings (
+    key text PRIMARY KEY,
+    value text NOT NULL
+);
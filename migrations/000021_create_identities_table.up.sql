@@ -0,0 +1,12 @@
+-- Accounts of an OpenID Connect provider linked to local users, which can log in with them
+CREATE TABLE IF NOT EXISTS identities (
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    user_id bigint NOT NULL,
+    email text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS identities_user_id_idx ON identities (user_id);
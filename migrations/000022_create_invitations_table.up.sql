@@ -0,0 +1,14 @@
+-- Single use links to create an account with a preset role
+CREATE TABLE IF NOT EXISTS invitations (
+    id serial PRIMARY KEY,
+    hash bytea NOT NULL UNIQUE,
+    role text NOT NULL,
+    created_by bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    used_by bigint,
+    used_at timestamp(0) with time zone, -- NULL while the invitation is pending
+    CONSTRAINT invitations_role_check CHECK (role IN ('viewer', 'contributor', 'editor', 'admin')),
+    CONSTRAINT fk_created_by FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL,
+    CONSTRAINT fk_used_by FOREIGN KEY(used_by) REFERENCES users(id) ON DELETE SET NULL
+);
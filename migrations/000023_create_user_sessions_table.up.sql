@@ -0,0 +1,13 @@
+-- Metadata of the logged in sessions. A session whose row is deleted is logged out at its next request
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id text PRIMARY KEY, -- Random id kept in the session data, not the session token
+    user_id bigint NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    CONSTRAINT fk_user_id FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON user_sessions (user_id);
@@ -0,0 +1,19 @@
+-- Who did what, kept after the actor or the target are deleted
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint, -- NULL if not logged in, or if the user was deleted
+    actor_name text NOT NULL DEFAULT '',
+    action text NOT NULL,
+    target_type text NOT NULL DEFAULT '',
+    target_id text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    before jsonb,
+    after jsonb,
+    CONSTRAINT fk_actor_id FOREIGN KEY(actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_action_idx ON audit_log (action);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
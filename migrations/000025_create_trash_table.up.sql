@@ -0,0 +1,16 @@
+-- Deleted photos and events, with everything needed to restore them.
+-- Their files are moved to trash/<id>/ in the storage
+CREATE TABLE IF NOT EXISTS trash (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    name text NOT NULL, -- File name of the photo or name of the event
+    event int NOT NULL, -- Event of the photo, or the event itself. It may not exist anymore
+    photos int NOT NULL DEFAULT 0,
+    deleted_by bigint,
+    deleted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    data jsonb NOT NULL, -- Rows of the deleted records, by table
+    CONSTRAINT trash_kind_check CHECK (kind IN ('photo', 'event')),
+    CONSTRAINT fk_user_id FOREIGN KEY(deleted_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS trash_deleted_at_idx ON trash (deleted_at);
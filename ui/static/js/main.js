@@ -43,16 +43,12 @@ function toggleSelected(e) {
     var img_src = e.getAttribute('src').split("/"); 
     var file = decodeURI(img_src[img_src.length-1]);
 
-    var delButton = document.getElementById("delButton");
-    var downloadButton = document.getElementById("downloadButton");
-
     var index = selected.indexOf(file);
     //was already selected
     if (index !== -1) {
         //was the only selected
         if (selected.length == 1) {
-            delButton?.classList.toggle("hidden");
-            downloadButton.classList.toggle("hidden");
+            toggleSelectedButtons();
             images = document.getElementsByClassName("photo-grid-item");
 
             //remove left click listener for all images
@@ -64,8 +60,7 @@ function toggleSelected(e) {
     } else { //wasn't selected
         //is the first to be selected
         if (selected.length == 0) {
-            delButton?.classList.toggle("hidden");
-            downloadButton.classList.toggle("hidden");
+            toggleSelectedButtons();
             images = document.getElementsByClassName("photo-grid-item");
 
             //add left click listener for all images
@@ -82,6 +77,29 @@ function toggleSelected(e) {
     e.classList.toggle("selected");
 }
 
+// Photos loaded while scrolling must be selectable with left click too, if a selection is in progress
+document.addEventListener("htmx:afterSwap", function() {
+    if (selected.length == 0) {
+        return;
+    }
+
+    images = document.getElementsByClassName("photo-grid-item");
+    for (i=0; i<images.length; i++) {
+        images[i].onclick= function(){
+            toggleSelected(this);
+            return false;
+        }
+    }
+});
+
+// Show or hide the buttons that act on the selected photos
+function toggleSelectedButtons() {
+    var buttons = document.querySelectorAll(".selectedButtons > *");
+    for (i=0; i<buttons.length; i++) {
+        buttons[i].classList.toggle("hidden");
+    }
+}
+
 function deleteSelected(event, token) {
     if (!confirm('Sei sicuro di voler cancellare le foto?')) {
         return
@@ -134,6 +152,96 @@ function downloadSelected(event, token) {
     });
 }
 
+function postHighlights(data) {
+    fetch("/events/highlights", {
+        method: "POST",
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(data),
+        redirect: "follow"
+    }).then(res => {
+            console.log("Request complete, response:", res);
+            location.reload();
+    })
+}
+
+function setSelectedAsCover(event, token) {
+    if (selected.length != 1) {
+        alert('Seleziona una sola foto come copertina');
+        return
+    }
+
+    postHighlights({
+        event: event,
+        cover: selected[0],
+        csrf_token: token
+    });
+}
+
+function setSelectedAsHighlights(event, token) {
+    postHighlights({
+        event: event,
+        highlights: selected,
+        csrf_token: token
+    });
+}
+
+function resetHighlights(event, token) {
+    postHighlights({
+        event: event,
+        cover: "",
+        highlights: [],
+        csrf_token: token
+    });
+}
+
+// The selected photos are passed to the share link form in the query string
+function shareSelected(event) {
+    var params = new URLSearchParams();
+    params.append("event", event);
+    for (var i = 0; i < selected.length; i++) {
+        params.append("photos", selected[i]);
+    }
+    window.location.href = "/shares/create?" + params.toString();
+}
+
+function addSelectedToAlbum(token) {
+    var data = {
+        album: parseInt(document.getElementById("albumSelect").value),
+        photos: selected,
+        csrf_token: token
+    };
+    fetch("/albums/add", {
+        method: "POST",
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(data),
+        redirect: "follow"
+    }).then(res => {
+            console.log("Request complete, response:", res);
+            location.reload();
+    })
+}
+
+function removeSelectedFromAlbum(album, token) {
+    if (!confirm('Sei sicuro di voler togliere le foto dall\'album?')) {
+        return
+    }
+
+    var data = {
+        album: album,
+        photos: selected,
+        csrf_token: token
+    };
+    fetch("/albums/remove", {
+        method: "POST",
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(data),
+        redirect: "follow"
+    }).then(res => {
+            console.log("Request complete, response:", res);
+            location.reload();
+    })
+}
+
 if (document.getElementById("map") == null) {
     var pmig = document.getElementsByClassName('photo-map-info-grid');
     for(i = 0; i < pmig.length; i++) {
@@ -0,0 +1,75 @@
+// Map of all geotagged photos. Photos are clustered by the server,
+// only the ones in the visible area are requested
+var photosMap = L.map('photosMap').setView([42, 12], 5);
+
+L.tileLayer('https://tile.openstreetmap.org/{z}/{x}/{y}.png', {
+    maxZoom: 19,
+    attribution: '&copy; <a href="http://www.openstreetmap.org/copyright">OpenStreetMap</a>'
+}).addTo(photosMap);
+
+var photosLayer = L.layerGroup().addTo(photosMap);
+var photosRequest = 0;
+
+function clusterMarker(feature, latlng) {
+    const count = feature.properties.count;
+
+    if (count == 1) {
+        return L.marker(latlng);
+    }
+
+    const size = 30 + Math.min(Math.log10(count) * 10, 30);
+    return L.marker(latlng, {
+        icon: L.divIcon({
+            html: count,
+            className: "map-cluster",
+            iconSize: [size, size],
+        }),
+    });
+}
+
+function loadPhotos() {
+    const bounds = photosMap.getBounds();
+    const bbox = [bounds.getWest(), bounds.getSouth(), bounds.getEast(), bounds.getNorth()].join(",");
+    const zoom = photosMap.getZoom();
+
+    // Only the last request is shown, in case responses arrive out of order
+    const request = ++photosRequest;
+
+    fetch(`/map/photos?bbox=${bbox}&zoom=${zoom}`)
+        .then(res => {
+            if (!res.ok) {
+                throw new Error(res.statusText);
+            }
+            return res.json();
+        })
+        .then(data => {
+            if (request != photosRequest) {
+                return;
+            }
+
+            photosLayer.clearLayers();
+            L.geoJSON(data, {
+                pointToLayer: clusterMarker,
+                onEachFeature: (feature, layer) => {
+                    const p = feature.properties;
+
+                    if (p.count == 1) {
+                        const popup = document.createElement("a");
+                        popup.className = "map-popup";
+                        popup.href = p.photo;
+                        const img = document.createElement("img");
+                        img.src = p.thumb;
+                        popup.appendChild(img);
+                        layer.bindPopup(popup);
+                    } else {
+                        // Clicking a cluster zooms in on it
+                        layer.on("click", () => photosMap.setView(layer.getLatLng(), Math.min(zoom + 2, 19)));
+                    }
+                },
+            }).addTo(photosLayer);
+        })
+        .catch(err => console.log(err));
+}
+
+photosMap.on("moveend", loadPhotos);
+loadPhotos();
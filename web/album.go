@@ -0,0 +1,430 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Retrieve the album with the id in the route
+func (app *Application) albumFromParams(w http.ResponseWriter, r *http.Request) (*models.Album, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	album, err := app.Models.Albums.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	err = app.setAlbumCovers(r, []*models.Album{album})
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return album, true
+}
+
+// Set the thumbnails of the covers, and hide the ones in events the user cannot view
+func (app *Application) setAlbumCovers(r *http.Request, albums []*models.Album) error {
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range albums {
+		if a.CoverPhoto == nil {
+			continue
+		}
+
+		if !hasAccess(access[a.CoverPhoto.Event], models.AccessView) {
+			a.CoverPhoto = nil
+			continue
+		}
+
+		app.setThumbNames([]*models.Photo{a.CoverPhoto})
+	}
+
+	return nil
+}
+
+func (app *Application) albumsPage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+
+	albums, err := app.Models.Albums.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.setAlbumCovers(r, albums)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.Albums = albums
+
+	app.render(w, r, http.StatusOK, "albums.tmpl", tdata)
+}
+
+func (app *Application) albumPage(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.albumFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	photos, err := app.Models.Albums.GetPhotos(album.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Photos of events the user cannot view are hidden
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.setThumbNames(photos)
+
+	tdata := app.newTemplateData(r)
+	tdata.Album = album
+	tdata.Photos = photos
+
+	app.render(w, r, http.StatusOK, "album.tmpl", tdata)
+}
+
+func (app *Application) albumDownload(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.albumFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	photos, err := app.Models.Albums.GetPhotos(album.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Photos of events the user cannot view are hidden
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sendPhotosZip(w, r, album.Name, photos, nil)
+}
+
+type albumForm struct {
+	Name                string `form:"name"`
+	Cover               int    `form:"cover"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) albumCreatePage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+	tdata.Form = albumForm{}
+	app.render(w, r, http.StatusOK, "albumCreate.tmpl", tdata)
+}
+
+func (app *Application) albumCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form albumForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	album := &models.Album{
+		Name: form.Name,
+	}
+
+	models.ValidateAlbum(&form.Validator, album)
+
+	if !form.Valid() {
+		tdata := app.newTemplateData(r)
+		tdata.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "albumCreate.tmpl", tdata)
+		return
+	}
+
+	err = app.Models.Albums.Insert(album)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", "Album created successfully. Add photos from the event pages by selecting them with right click")
+
+	http.Redirect(w, r, fmt.Sprintf("/albums/view/%d", album.ID), http.StatusSeeOther)
+}
+
+func (app *Application) albumUpdatePage(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.albumFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	photos, err := app.Models.Albums.GetPhotos(album.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Photos of events the user cannot view are hidden
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form := albumForm{Name: album.Name}
+	if album.Cover != nil {
+		form.Cover = *album.Cover
+	}
+
+	tdata := app.newTemplateData(r)
+	tdata.Form = form
+	tdata.Album = album
+	tdata.Photos = photos
+	app.render(w, r, http.StatusOK, "albumUpdate.tmpl", tdata)
+}
+
+func (app *Application) albumUpdatePost(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.albumFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form albumForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	photos, err := app.Models.Albums.GetPhotos(album.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Photos of events the user cannot view are hidden
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	previousCover := album.Cover
+
+	album.Name = form.Name
+	album.Cover = nil
+	if form.Cover != 0 {
+		album.Cover = &form.Cover
+	}
+
+	models.ValidateAlbum(&form.Validator, album)
+
+	// The cover must be one of the album's photos, the current one is kept even if the user cannot view it
+	if album.Cover != nil && (previousCover == nil || *previousCover != *album.Cover) {
+		found := false
+		for _, p := range photos {
+			if p.ID == *album.Cover {
+				found = true
+				break
+			}
+		}
+		form.CheckField(found, "cover", "The cover must be a photo of the album")
+	}
+
+	if !form.Valid() {
+		tdata := app.newTemplateData(r)
+		tdata.Form = form
+		tdata.Album = album
+		tdata.Photos = photos
+		app.render(w, r, http.StatusUnprocessableEntity, "albumUpdate.tmpl", tdata)
+		return
+	}
+
+	err = app.Models.Albums.Update(album)
+	if err != nil {
+		if errors.Is(err, models.ErrEditConflict) {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", "Album updated successfully")
+
+	http.Redirect(w, r, fmt.Sprintf("/albums/view/%d", album.ID), http.StatusSeeOther)
+}
+
+func (app *Application) albumDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	album, ok := app.albumFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Only the album is deleted, its photos stay in their events
+	err := app.Models.Albums.Delete(album.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("album deleted",
+		"requestId", requestId,
+		"albumID", album.ID,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Album deleted successfully")
+
+	http.Redirect(w, r, "/albums", http.StatusSeeOther)
+}
+
+func (app *Application) albumAddPhotos(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token  string   `json:"csrf_token"` // only needed by readJSON since it checks for unknown keys
+		Album  int      `json:"album"`
+		Photos []string `json:"photos"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	files := photoFilesFromThumbs(input.Photos)
+
+	// Only photos of the events the user can view can be added
+	for _, file := range files {
+		photo, err := app.Models.Photos.GetByFile(file)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				app.clientError(w, http.StatusNotFound)
+				return
+			}
+
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !app.checkEventAccess(w, r, photo.Event, models.AccessView) {
+			return
+		}
+	}
+
+	err = app.Models.Albums.AddPhotos(input.Album, files)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", "Photos added to the album")
+}
+
+func (app *Application) albumRemovePhotos(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token  string   `json:"csrf_token"` // only needed by readJSON since it checks for unknown keys
+		Album  int      `json:"album"`
+		Photos []string `json:"photos"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.Models.Albums.GetByID(input.Album)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.Models.Albums.RemovePhotos(input.Album, photoFilesFromThumbs(input.Photos))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", "Photos removed from the album")
+}
+
+type albumMoveForm struct {
+	Photo string `form:"photo"`
+	Up    bool   `form:"up"`
+}
+
+func (app *Application) albumMovePhoto(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.albumFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form albumMoveForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Albums.MovePhoto(album.ID, form.Photo, form.Up)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/albums/view/%d", album.ID), http.StatusSeeOther)
+}
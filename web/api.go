@@ -0,0 +1,883 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The JSON API under /api/v1 uses the same session and CSRF protection as the site:
+// requests that change something must send the CSRF token in the X-CSRF-Token header
+// (or in the csrf_token field of JSON bodies).
+
+type eventResponse struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Date        string   `json:"date,omitempty"`
+	EndDate     string   `json:"end_date,omitempty"`
+	Location    string   `json:"location"`
+	Latitude    *float32 `json:"latitude,omitempty"`
+	Longitude   *float32 `json:"longitude,omitempty"`
+	Parent      *int     `json:"parent,omitempty"`
+	Category    string   `json:"category"`
+	Cover       *int     `json:"cover,omitempty"`
+	Highlights  []int    `json:"highlights"`
+	Version     int      `json:"version"`
+}
+
+func newEventResponse(event *models.Event) eventResponse {
+	res := eventResponse{
+		ID:          event.ID,
+		Name:        event.Name,
+		Description: event.Description,
+		Location:    event.Location,
+		Latitude:    event.Latitude,
+		Longitude:   event.Longitude,
+		Parent:      event.Parent,
+		Category:    event.Category,
+		Cover:       event.Cover,
+		Highlights:  event.Highlights,
+		Version:     event.Version,
+	}
+
+	if event.Date != nil {
+		res.Date = event.Date.Format(time.DateOnly)
+	}
+	if event.EndDate != nil {
+		res.EndDate = event.EndDate.Format(time.DateOnly)
+	}
+	if res.Highlights == nil {
+		res.Highlights = []int{}
+	}
+
+	return res
+}
+
+type photoResponse struct {
+	ID           int        `json:"id"`
+	FileName     string     `json:"file_name"`
+	URL          string     `json:"url"`
+	ThumbnailURL string     `json:"thumbnail_url"`
+	CreatedAt    time.Time  `json:"created_at"`
+	TakenAt      *time.Time `json:"taken_at,omitempty"`
+	Latitude     *float32   `json:"latitude,omitempty"`
+	Longitude    *float32   `json:"longitude,omitempty"`
+	Event        int        `json:"event"`
+	Uploader     *int       `json:"uploader,omitempty"`
+	PreviousFile *string    `json:"previous_file,omitempty"`
+	NextFile     *string    `json:"next_file,omitempty"`
+	IsFavourite  bool       `json:"is_favourite"`
+	Favourites   int        `json:"favourites,omitempty"` // Only counted for admins
+}
+
+// Thumbnail names must have been set
+func newPhotoResponse(photo *models.Photo) photoResponse {
+	return photoResponse{
+		ID:           photo.ID,
+		FileName:     photo.FileName,
+		URL:          fmt.Sprintf("/storage/photos/%d/%s", photo.Event, photo.FileName),
+		ThumbnailURL: fmt.Sprintf("/storage/thumbnails/%d/%s", photo.Event, photo.ThumbName),
+		CreatedAt:    photo.CreatedAt,
+		TakenAt:      photo.TakenAt,
+		Latitude:     photo.Latitude,
+		Longitude:    photo.Longitude,
+		Event:        photo.Event,
+		Uploader:     photo.Uploader,
+		PreviousFile: photo.PreviousFile,
+		NextFile:     photo.NextFile,
+		IsFavourite:  photo.IsFavourite,
+		Favourites:   photo.Favourites,
+	}
+}
+
+func newPhotoResponses(photos []*models.Photo) []photoResponse {
+	res := make([]photoResponse, len(photos))
+	for i, p := range photos {
+		res[i] = newPhotoResponse(p)
+	}
+
+	return res
+}
+
+// A JSON field that distinguishes between being absent and being null
+type optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *optional[T]) UnmarshalJSON(b []byte) error {
+	o.Set = true
+
+	if string(b) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var v T
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+
+	o.Value = &v
+	return nil
+}
+
+// Overwrite a form value if the field was sent, null empties it
+func setOptional[T any](dst *string, o optional[T], format func(T) string) {
+	if !o.Set {
+		return
+	}
+
+	*dst = ""
+	if o.Value != nil {
+		*dst = format(*o.Value)
+	}
+}
+
+// Fields of an event sent by clients. Absent fields are left unchanged
+type eventInput struct {
+	Token       string            `json:"csrf_token"` // only needed by readJSON since it checks for unknown keys
+	Name        *string           `json:"name"`
+	Description *string           `json:"description"`
+	Date        optional[string]  `json:"date"` // YYYY-MM-DD
+	EndDate     optional[string]  `json:"end_date"`
+	Location    *string           `json:"location"`
+	Latitude    optional[float32] `json:"latitude"`
+	Longitude   optional[float32] `json:"longitude"`
+	Parent      optional[int]     `json:"parent"`
+	Category    *string           `json:"category"`
+	Version     *int              `json:"version"`
+}
+
+// Copy the input in the form, so that it goes through the same validation as the site's forms
+func (input *eventInput) apply(form *eventCreateForm) {
+	formatString := func(s string) string { return s }
+	formatCoord := func(c float32) string { return strconv.FormatFloat(float64(c), 'f', -1, 32) }
+
+	if input.Name != nil {
+		form.Name = *input.Name
+	}
+	if input.Description != nil {
+		form.Description = *input.Description
+	}
+	setOptional(&form.Date, input.Date, formatString)
+	setOptional(&form.EndDate, input.EndDate, formatString)
+	if input.Location != nil {
+		form.Location = *input.Location
+	}
+	setOptional(&form.Latitude, input.Latitude, formatCoord)
+	setOptional(&form.Longitude, input.Longitude, formatCoord)
+	if input.Parent.Set {
+		form.Parent = 0
+		if input.Parent.Value != nil {
+			form.Parent = *input.Parent.Value
+		}
+	}
+	if input.Category != nil {
+		form.Category = *input.Category
+	}
+	if input.Version != nil {
+		form.Version = *input.Version
+	}
+}
+
+// Read the :id parameter, responding with not found if it is not valid
+func (app *Application) apiEventFromParams(w http.ResponseWriter, r *http.Request) (*models.Event, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.apiNotFound(w, r)
+		return nil, false
+	}
+
+	event, err := app.Models.Events.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.apiNotFound(w, r)
+			return nil, false
+		}
+
+		app.apiServerError(w, r, err)
+		return nil, false
+	}
+
+	return event, true
+}
+
+// Like checkEventAccess, but responds with a JSON error
+func (app *Application) apiCheckEventAccess(w http.ResponseWriter, r *http.Request, event int, want string) bool {
+	access, err := app.eventAccess(r, event)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return false
+	}
+
+	if !hasAccess(access, want) {
+		app.apiNotFound(w, r)
+		return false
+	}
+
+	return true
+}
+
+// Pages of events, ?sort can be id, name or day, prefixed by - for descending order
+func (app *Application) apiEventsList(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.Validator{}
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, &v),
+		PageSize:     app.readInt(qs, "page_size", 20, &v),
+		Sort:         app.readString(qs, "sort", "-day"),
+		SortSafelist: []string{"id", "name", "day", "-id", "-name", "-day"},
+	}
+
+	data.ValidateFilters(&v, filters)
+	if !v.Valid() {
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	// Users who can access every event see them all, other users only the ones they can view
+	var ids []int
+	if !app.Can(r, models.PermissionEventsAccess) {
+		access, err := app.eventsAccess(r)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		ids = []int{}
+		for id, a := range access {
+			if hasAccess(a, models.AccessView) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	events, metadata, err := app.Models.Events.GetFiltered(filters, ids)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	res := make([]eventResponse, len(events))
+	for i, e := range events {
+		res[i] = newEventResponse(e)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": res, "metadata": metadata}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *Application) apiEventShow(w http.ResponseWriter, r *http.Request) {
+	event, ok := app.apiEventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.apiCheckEventAccess(w, r, event.ID, models.AccessView) {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"event": newEventResponse(event)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *Application) apiEventCreate(w http.ResponseWriter, r *http.Request) {
+	var input eventInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+
+	var form eventCreateForm
+	input.apply(&form)
+
+	options, err := app.eventParentOptions(r, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	event := &models.Event{}
+	form.setEvent(event)
+	form.checkParent(options)
+
+	if !form.Valid() {
+		app.apiFailedValidation(w, r, form.FieldErrors)
+		return
+	}
+
+	err = app.Models.Events.Insert(event)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditEventCreate, "event", event.ID, nil, event)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/events/%d", event.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"event": newEventResponse(event)}, headers)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// Partial update of an event. If the version is sent, the update fails when the event
+// has been changed in the meantime
+func (app *Application) apiEventUpdate(w http.ResponseWriter, r *http.Request) {
+	event, ok := app.apiEventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.apiCheckEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
+	}
+
+	before := *event
+
+	var input eventInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+
+	form := newEventCreateForm(event)
+	input.apply(&form)
+	event.Version = form.Version
+
+	options, err := app.eventParentOptions(r, event)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	form.setEvent(event)
+	form.checkParent(options)
+
+	err = app.checkParentChange(r, &form, &before)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		app.apiFailedValidation(w, r, form.FieldErrors)
+		return
+	}
+
+	err = app.Models.Events.Update(event)
+	if err != nil {
+		if errors.Is(err, models.ErrEditConflict) {
+			app.apiEditConflict(w, r)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditEventUpdate, "event", event.ID, before, event)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"event": newEventResponse(event)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *Application) apiEventDelete(w http.ResponseWriter, r *http.Request) {
+	event, ok := app.apiEventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.deleteEvent(event.ID, app.UserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.apiNotFound(w, r)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditEventDelete, "event", event.ID, event, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "event successfully deleted"}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// Pages of photos, with the same filters as the event page. ?event restricts them to an event,
+// the next page is requested by sending metadata.next_cursor as ?cursor
+func (app *Application) apiPhotosList(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	form, filters := app.readPhotoFilters(qs)
+	eventID := app.readInt(qs, "event", 0, &form.Validator)
+	form.CheckField(eventID >= 0, "event", "invalid event")
+
+	if !form.Valid() {
+		app.apiFailedValidation(w, r, form.FieldErrors)
+		return
+	}
+
+	var event *int
+	if eventID != 0 {
+		_, err := app.Models.Events.GetByID(eventID)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				app.apiNotFound(w, r)
+				return
+			}
+
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		if !app.apiCheckEventAccess(w, r, eventID, models.AccessView) {
+			return
+		}
+
+		event = &eventID
+	}
+
+	photos, metadata, err := app.Models.Photos.GetFiltered(event, filters)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	// Without an event, photos of the events the user cannot view are removed from the page,
+	// so it can have fewer photos than requested. The cursor still points after the whole page
+	if event == nil {
+		photos, err = app.accessiblePhotos(r, photos)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+	}
+
+	app.setThumbNames(photos)
+
+	// Favourites are loaded per event
+	if event != nil {
+		err = app.setFavourites(r, *event, photos)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"photos": newPhotoResponses(photos), "metadata": metadata}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// Read the :file parameter, responding with not found if there is no such photo
+func (app *Application) apiPhotoFromParams(w http.ResponseWriter, r *http.Request) (*models.Photo, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	photo, err := app.Models.Photos.GetByFile(params.ByName("file"))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.apiNotFound(w, r)
+			return nil, false
+		}
+
+		app.apiServerError(w, r, err)
+		return nil, false
+	}
+
+	return photo, true
+}
+
+func (app *Application) apiPhotoShow(w http.ResponseWriter, r *http.Request) {
+	photo, ok := app.apiPhotoFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.apiCheckEventAccess(w, r, photo.Event, models.AccessView) {
+		return
+	}
+
+	photos := []*models.Photo{photo}
+	app.setThumbNames(photos)
+
+	err := app.setFavourites(r, photo.Event, photos)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"photo": newPhotoResponse(photo)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+type photoInput struct {
+	Token     string              `json:"csrf_token"` // only needed by readJSON since it checks for unknown keys
+	TakenAt   optional[time.Time] `json:"taken_at"`   // RFC 3339
+	Latitude  optional[float32]   `json:"latitude"`
+	Longitude optional[float32]   `json:"longitude"`
+}
+
+// Partial update of the time and the location of a photo, null empties a field
+func (app *Application) apiPhotoUpdate(w http.ResponseWriter, r *http.Request) {
+	photo, ok := app.apiPhotoFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.apiCheckEventAccess(w, r, photo.Event, models.AccessContribute) {
+		return
+	}
+
+	before := *photo
+
+	var input photoInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+
+	if input.TakenAt.Set {
+		photo.TakenAt = input.TakenAt.Value
+	}
+	if input.Latitude.Set {
+		photo.Latitude = input.Latitude.Value
+	}
+	if input.Longitude.Set {
+		photo.Longitude = input.Longitude.Value
+	}
+
+	v := validator.Validator{}
+	models.ValidatePhoto(&v, photo)
+	if !v.Valid() {
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	err = app.Models.Photos.Update(photo)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.apiNotFound(w, r)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditPhotoUpdate, "photo", photo.ID, before, photo)
+
+	photos := []*models.Photo{photo}
+	app.setThumbNames(photos)
+
+	err = app.setFavourites(r, photo.Event, photos)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"photo": newPhotoResponse(photo)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// Upload photos to an event, with a multipart form containing the event and the files.
+// Files that are rejected are listed in the response, along with the stored ones
+func (app *Application) apiPhotosCreate(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	err := r.ParseMultipartForm(32 << 20) // 32MB
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+
+	v := validator.Validator{}
+
+	eventID := app.readInt(r.MultipartForm.Value, "event", 0, &v)
+	v.CheckField(eventID > 0, "event", "must be a valid event")
+
+	files := r.MultipartForm.File["files"]
+	v.CheckField(len(files) > 0, "files", "must contain at least one file")
+
+	if !v.Valid() {
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	event, err := app.Models.Events.GetByID(eventID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			v.AddFieldError("event", "event not found")
+			app.apiFailedValidation(w, r, v.FieldErrors)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	access, err := app.eventAccess(r, event.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	if !hasAccess(access, models.AccessContribute) {
+		v.AddFieldError("event", "you cannot upload photos to this event")
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	photos := []*models.Photo{}
+	rejected := []string{}
+
+	for _, file := range files {
+		photo, err := app.storePhoto(requestId, event, file, app.UserID(r))
+		if err != nil {
+			var rejection photoRejectedError
+			if errors.As(err, &rejection) {
+				rejected = append(rejected, string(rejection))
+				continue
+			}
+
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		app.audit(r, models.AuditPhotoUpload, "photo", photo.ID, nil, photo)
+
+		photos = append(photos, photo)
+	}
+
+	if len(photos) == 0 {
+		v.AddFieldError("files", strings.Join(rejected, "\n"))
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	app.setThumbNames(photos)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"photos": newPhotoResponses(photos), "rejected": rejected}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *Application) apiPhotoDelete(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	photo, ok := app.apiPhotoFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.apiCheckEventAccess(w, r, photo.Event, models.AccessContribute) {
+		return
+	}
+
+	err := app.deletePhoto(photo, app.UserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.apiNotFound(w, r)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("photo deleted",
+		"requestId", requestId,
+		"filename", photo.FileName,
+		"eventID", photo.Event,
+	)
+
+	app.audit(r, models.AuditPhotoDelete, "photo", photo.ID, photo, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "photo successfully deleted"}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+type userResponse struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newUserResponse(user *models.User) userResponse {
+	return userResponse{
+		ID:        user.ID,
+		Name:      user.Name,
+		Role:      user.Role,
+		Active:    user.Active,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// Pages of users, ordered by name
+func (app *Application) apiUsersList(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.Validator{}
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, &v),
+		PageSize:     app.readInt(qs, "page_size", 20, &v),
+		Sort:         "name",
+		SortSafelist: []string{"name"},
+	}
+
+	data.ValidateFilters(&v, filters)
+	if !v.Valid() {
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	// Users are few, so they are paginated here instead of in the query
+	users, err := app.Models.Users.GetAll()
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	metadata := data.CalculateMetadata(len(users), filters.Page, filters.PageSize)
+
+	res := []userResponse{}
+	for i := filters.Offset(); i < len(users) && i < filters.Offset()+filters.Limit(); i++ {
+		res = append(res, newUserResponse(users[i]))
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": res, "metadata": metadata}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+// Users can read their own account, those who manage users can read all of them
+func (app *Application) apiUserShow(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.apiNotFound(w, r)
+		return
+	}
+
+	if id != app.UserID(r) && !app.Can(r, models.PermissionUsersManage) {
+		app.apiNotPermitted(w, r)
+		return
+	}
+
+	user, err := app.Models.Users.GetById(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.apiNotFound(w, r)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": newUserResponse(user)}, nil)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *Application) apiUserCreate(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"csrf_token"` // only needed by readJSON since it checks for unknown keys
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiBadRequest(w, r, err)
+		return
+	}
+
+	user := &models.User{
+		Name: input.Name,
+		Role: input.Role,
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	v := validator.Validator{}
+	models.ValidateUser(&v, user)
+	if !v.Valid() {
+		app.apiFailedValidation(w, r, v.FieldErrors)
+		return
+	}
+
+	err = app.Models.Users.Insert(user)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateName) {
+			v.AddFieldError("name", "Name is already in use")
+			app.apiFailedValidation(w, r, v.FieldErrors)
+			return
+		}
+
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditUserCreate, "user", user.ID, nil, newUserResponse(user))
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/users/%d", user.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": newUserResponse(user)}, headers)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
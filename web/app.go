@@ -9,6 +9,7 @@ import (
 	"html/template"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"sitoWow/internal/data/models"
@@ -20,17 +21,32 @@ import (
 )
 
 type Config struct {
-	Port       int
-	Env        string
-	StaticDir  string
-	StorageDir string
-	DB         struct {
+	Port           int
+	Env            string
+	StaticDir      string
+	StorageDir     string
+	TrashRetention time.Duration  // Deleted photos and events are purged after it, never if 0
+	TrustedProxies []netip.Prefix // Requests from them are attributed to the address in X-Real-Ip or X-Forwarded-For
+	DB             struct {
 		Dsn           string
 		MigrationsDir string
 		MaxOpenConns  int
 		MaxIdleConns  int
 		MaxIdleTime   string
 	}
+	// Single sign-on is disabled when Issuer is empty
+	OIDC struct {
+		Issuer        string
+		ClientID      string
+		ClientSecret  string
+		RedirectURL   string
+		Scopes        string // Space separated
+		GroupsClaim   string
+		GroupRoles    string // Role of the members of each group, as "group=role,group=role"
+		LinkByEmail   bool   // Link accounts to the user whose name is their verified email
+		AutoProvision bool   // Create users that log in for the first time
+		DefaultRole   string // Role of the created users that are in no mapped group
+	}
 }
 
 type Application struct {
@@ -41,6 +57,7 @@ type Application struct {
 	TemplateCache  map[string]*template.Template
 	FormDecoder    *form.Decoder
 	SessionManager *scs.SessionManager
+	OIDC           *OIDCProvider // nil if single sign-on is disabled
 }
 
 func (app *Application) Serve() error {
@@ -75,6 +92,10 @@ func (app *Application) Serve() error {
 		shutdownError <- srv.Shutdown(ctx)
 	}()
 
+	if app.Config.TrashRetention > 0 {
+		go app.purgeTrash()
+	}
+
 	app.Logger.Info("starting server",
 		"addr", srv.Addr,
 		"env", app.Config.Env,
@@ -3,5 +3,7 @@ package web
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
-const userLevelContextKey = contextKey("userLevel")
+const userRoleContextKey = contextKey("userRole")
+const userIDContextKey = contextKey("userID")
 const requestIdContextKey = contextKey("requestId")
+const tokenContextKey = contextKey("token")
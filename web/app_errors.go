@@ -38,3 +38,60 @@ func (app *Application) clientError(w http.ResponseWriter, status int) {
 func (app *Application) clientErrorHTMX(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), http.StatusOK)
 }
+
+// API errors are sent as {"error": message}, where message is either a string or,
+// for invalid input, a map from field names to their errors
+func (app *Application) apiErrorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	err := app.writeJSON(w, status, envelope{"error": message}, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (app *Application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := "the server encountered a problem and could not process your request"
+	app.apiErrorResponse(w, r, http.StatusInternalServerError, message)
+}
+
+func (app *Application) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource could not be found"
+	app.apiErrorResponse(w, r, http.StatusNotFound, message)
+}
+
+func (app *Application) apiBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	app.apiErrorResponse(w, r, http.StatusBadRequest, err.Error())
+}
+
+func (app *Application) apiFailedValidation(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.apiErrorResponse(w, r, http.StatusUnprocessableEntity, errors)
+}
+
+func (app *Application) apiEditConflict(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.apiErrorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *Application) apiAuthenticationRequired(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.apiErrorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *Application) apiNotPermitted(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.apiErrorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *Application) apiTwoFactorRequired(w http.ResponseWriter, r *http.Request) {
+	message := "administrators must enable two-factor authentication before using this resource"
+	app.apiErrorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *Application) apiInvalidToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.apiErrorResponse(w, r, http.StatusUnauthorized, message)
+}
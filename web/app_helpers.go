@@ -2,17 +2,23 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"path"
 	"path/filepath"
 	"sitoWow/internal/data/models"
 	"sitoWow/internal/validator"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/form/v4"
 )
@@ -69,13 +75,176 @@ func (app *Application) IsAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
-func (app *Application) IsAdmin(r *http.Request) bool {
-	level, ok := r.Context().Value(userLevelContextKey).(int)
-	if !ok || level < models.ADMIN_LEVEL {
+// Returns the role of the authenticated user, or an empty string if the user is not authenticated
+func (app *Application) UserRole(r *http.Request) string {
+	role, ok := r.Context().Value(userRoleContextKey).(string)
+	if !ok {
+		return ""
+	}
+
+	return role
+}
+
+// Whether the role of the authenticated user grants the permission
+func (app *Application) Can(r *http.Request, permission string) bool {
+	return models.RoleHas(app.UserRole(r), permission)
+}
+
+// Returns the id of the authenticated user, or 0 if the user is not authenticated
+func (app *Application) UserID(r *http.Request) int {
+	id, ok := r.Context().Value(userIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+
+	return id
+}
+
+// Record the metadata of the session the user just logged in with
+func (app *Application) registerSession(r *http.Request, user int) error {
+	session, err := models.GenerateSession(user, app.clientIP(r), r.UserAgent(), time.Now().Add(app.SessionManager.Lifetime))
+	if err != nil {
+		return err
+	}
+
+	err = app.Models.Sessions.Insert(session)
+	if err != nil {
+		return err
+	}
+
+	app.SessionManager.Put(r.Context(), "sessionID", session.ID)
+
+	return nil
+}
+
+// Log the user out of every session, except for the current one
+func (app *Application) destroyUserSessions(ctx context.Context, user int) error {
+	current := app.SessionManager.GetString(ctx, "sessionID")
+
+	// Sessions without metadata are logged out by authenticate
+	err := app.Models.Sessions.DeleteForUser(user, current)
+	if err != nil {
+		return err
+	}
+
+	return app.SessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if app.SessionManager.GetInt(ctx, "authenticatedUserID") != user || app.SessionManager.GetString(ctx, "sessionID") == current {
+			return nil
+		}
+
+		return app.SessionManager.Destroy(ctx)
+	})
+}
+
+// Parse a comma separated list of addresses and CIDR ranges
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", part)
+			}
+
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", part)
+		}
+
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	return proxies, nil
+}
+
+// Whether the address is one of the proxies in the configuration
+func (app *Application) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
 		return false
 	}
 
-	return true
+	addr = addr.Unmap()
+	for _, prefix := range app.Config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Address of the client, without the port. When the request comes from a trusted proxy it
+// is read from X-Real-Ip, or else from the last address in X-Forwarded-For that is not a
+// trusted proxy, since the ones before it can be set by the client
+func (app *Application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !app.trustedProxy(ip) {
+		return ip
+	}
+
+	realIP := strings.TrimSpace(r.Header.Get("X-Real-Ip"))
+	if _, err := netip.ParseAddr(realIP); err == nil {
+		return realIP
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if _, err := netip.ParseAddr(addr); err != nil {
+			break
+		}
+
+		ip = addr
+		if !app.trustedProxy(addr) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func isVideoFile(f string) bool {
+	return slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(f)))
+}
+
+// Selected photos are identified by their thumbnail, which for videos is the video file name + ".jpg"
+func photoFilesFromThumbs(thumbs []string) []string {
+	files := make([]string, len(thumbs))
+	for i, thumb := range thumbs {
+		files[i] = thumb
+		if isVideoFile(strings.TrimSuffix(thumb, ".jpg")) {
+			files[i] = strings.TrimSuffix(thumb, ".jpg")
+		}
+	}
+
+	return files
+}
+
+// Set thubnail names, replace video extensions with jpg extension (for thumbnail path)
+func (app *Application) setThumbNames(photos []*models.Photo) {
+	for i := range photos {
+		if isVideoFile(photos[i].FileName) {
+			// Thumbnail for video is video filename(with extension)+".jpg"
+			photos[i].ThumbName = fmt.Sprintf("%s%s", path.Base(photos[i].FileName), ".jpg")
+		} else {
+			photos[i].ThumbName = photos[i].FileName
+		}
+	}
 }
 
 func (app *Application) InAllowedPath(path string, trustedRoot string) bool {
@@ -145,6 +314,27 @@ func (app *Application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+type envelope map[string]any
+
+func (app *Application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
+
 func (app *Application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	maxBytes := 1_048_576 // 1MB
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sitoWow/internal/data/models"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/justinas/nosurf"
@@ -16,7 +19,7 @@ func (app *Application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// unsafe-inline is probably really insecure, oh well..
 		w.Header().Set("Content-Security-Policy",
-			"default-src 'self' localhost; style-src 'self' 'unsafe-inline' fonts.googleapis.com cdn.jsdelivr.net unpkg.com; font-src 'self' fonts.gstatic.com; script-src 'self' 'unsafe-inline' unpkg.com; img-src 'self' localhost tile.openstreetmap.org unpkg.com")
+			"default-src 'self' localhost; style-src 'self' 'unsafe-inline' fonts.googleapis.com cdn.jsdelivr.net unpkg.com; font-src 'self' fonts.gstatic.com; script-src 'self' 'unsafe-inline' unpkg.com; img-src 'self' data: localhost tile.openstreetmap.org unpkg.com")
 
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -82,21 +85,64 @@ func (app *Application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
-func (app *Application) requireAdmin(next http.Handler) http.Handler {
+// Users whose role does not grant the permission are forbidden
+func (app *Application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.IsAuthenticated(r) {
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			if !app.Can(r, permission) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			// so that pages that require authentication are not
+			// stored in the users browser cache
+			w.Header().Add("Cache-Control", "no-store")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Like requireAuthentication, but responds with a JSON error instead of redirecting
+func (app *Application) apiRequireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !app.IsAuthenticated(r) || !app.IsAdmin(r) {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		if !app.IsAuthenticated(r) {
+			app.apiAuthenticationRequired(w, r)
 			return
 		}
 
-		// so that pages that require authentication are not
-		// stored in the users browser cache
 		w.Header().Add("Cache-Control", "no-store")
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Like requirePermission, but responds with a JSON error instead of redirecting
+func (app *Application) apiRequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.IsAuthenticated(r) {
+				app.apiAuthenticationRequired(w, r)
+				return
+			}
+
+			if !app.Can(r, permission) {
+				app.apiNotPermitted(w, r)
+				return
+			}
+
+			w.Header().Add("Cache-Control", "no-store")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *Application) noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -107,6 +153,11 @@ func (app *Application) noSurf(next http.Handler) http.Handler {
 
 	// Manually check json requests
 	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		// Requests authenticated by authenticateToken do not use cookies, so they cannot be forged
+		if _, ok := r.Context().Value(tokenContextKey).(*models.Token); ok {
+			return true
+		}
+
 		if r.Header.Get("Content-Type") != "application/json" {
 			return false
 		}
@@ -150,17 +201,111 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, level, err := app.Models.Users.Exists(id)
+		exists, role, err := app.Models.Users.Exists(id)
 		if err != nil {
 			app.serverError(w, r, err)
+			return
+		}
+
+		// Sessions logged in before their metadata was recorded get it now
+		sessionID := app.SessionManager.GetString(r.Context(), "sessionID")
+		if exists && sessionID == "" {
+			err = app.registerSession(r, id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		} else if exists {
+			active, err := app.Models.Sessions.Seen(sessionID, id, app.clientIP(r))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			// Revoked by the user or by an admin
+			if !active {
+				err = app.SessionManager.Destroy(r.Context())
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+
+				exists = false
+			}
 		}
 
 		if exists {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			ctx = context.WithValue(ctx, userLevelContextKey, level)
+			ctx = context.WithValue(ctx, userRoleContextKey, role)
+			ctx = context.WithValue(ctx, userIDContextKey, id)
 			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Authenticate requests with an "Authorization: Bearer <token>" header, for clients that
+// cannot use sessions. It sets the same context keys as authenticate, replacing the session's user
+func (app *Application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		plaintext, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
+		if !ok || plaintext == "" {
+			app.apiInvalidToken(w, r)
+			return
+		}
+
+		token, err := app.Models.Tokens.Authenticate(plaintext)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.apiInvalidToken(w, r)
+				return
+			}
+
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		exists, role, err := app.Models.Users.Exists(token.UserID)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+		if !exists {
+			app.apiInvalidToken(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, userRoleContextKey, role)
+		ctx = context.WithValue(ctx, userIDContextKey, token.UserID)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Requests authenticated with a token must have the scope, session requests are not restricted
+func (app *Application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(tokenContextKey).(*models.Token)
+			if ok && !token.HasScope(scope) {
+				message := fmt.Sprintf("the token does not have the %s scope", scope)
+				app.apiErrorResponse(w, r, http.StatusForbidden, message)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sitoWow/internal/data/models"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	app := &Application{}
+
+	tests := []struct {
+		name   string
+		token  *models.Token // Session request if nil
+		scope  string
+		status int
+	}{
+		{name: "session request", scope: models.ScopeUpload, status: http.StatusOK},
+		{name: "token with the scope", token: &models.Token{Scopes: []string{models.ScopeRead}}, scope: models.ScopeRead, status: http.StatusOK},
+		{name: "token without the scope", token: &models.Token{Scopes: []string{models.ScopeRead}}, scope: models.ScopeUpload, status: http.StatusForbidden},
+		{name: "token without scopes", token: &models.Token{}, scope: models.ScopeRead, status: http.StatusForbidden},
+		{name: "admin token", token: &models.Token{Scopes: []string{models.ScopeAdmin}}, scope: models.ScopeUpload, status: http.StatusOK},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+			if tt.token != nil {
+				r = r.WithContext(context.WithValue(r.Context(), tokenContextKey, tt.token))
+			}
+
+			rr := httptest.NewRecorder()
+			app.requireScope(tt.scope)(next).ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("got status %d, want %d", rr.Code, tt.status)
+			}
+		})
+	}
+}
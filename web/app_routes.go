@@ -3,6 +3,7 @@ package web
 import (
 	"net/http"
 	"path/filepath"
+	"sitoWow/internal/data/models"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
@@ -25,33 +26,141 @@ func (app *Application) Routes() http.Handler {
 
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLoginPage))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.loginTwoFactorPage))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.loginTwoFactorPost))
+	router.Handler(http.MethodGet, "/user/login/sso", dynamic.ThenFunc(app.ssoLogin))
+	router.Handler(http.MethodGet, "/user/login/sso/callback", dynamic.ThenFunc(app.ssoCallback))
+	router.Handler(http.MethodGet, "/share/:token", dynamic.ThenFunc(app.sharePage))
+	router.Handler(http.MethodPost, "/share/:token", dynamic.ThenFunc(app.shareUnlockPost))
+	router.Handler(http.MethodGet, "/share/:token/download", dynamic.ThenFunc(app.shareDownload))
+	router.Handler(http.MethodGet, "/invite/:token", dynamic.ThenFunc(app.invitationPage))
+	router.Handler(http.MethodPost, "/invite/:token", dynamic.ThenFunc(app.invitationAcceptPost))
 
 	// LOGIN REQUIRED
-	protected := dynamic.Append(app.requireAuthentication)
+	// Administrators that must enable the two factor can only reach the pages to do it, and log out
+	authenticated := dynamic.Append(app.requireAuthentication)
+	router.Handler(http.MethodPost, "/user/logout", authenticated.ThenFunc(app.userLogout))
+	router.Handler(http.MethodGet, "/user/2fa", authenticated.ThenFunc(app.twoFactorPage))
+	router.Handler(http.MethodPost, "/user/2fa/enable", authenticated.ThenFunc(app.twoFactorEnablePost))
+	router.Handler(http.MethodPost, "/user/2fa/disable", authenticated.ThenFunc(app.twoFactorDisablePost))
 
-	// Added headers that allow files to be cached only by local browser, after protected in order to overwrite the header authenticate sets
-	router.Handler(http.MethodGet, "/storage/*filepath", protected.Then(app.staticCacheHeaders(http.StripPrefix("/storage", storageServer))))
+	protected := authenticated.Append(app.requireTwoFactor)
+
+	// Added headers that allow files to be cached only by local browser, after protected in order to overwrite the header authenticate sets.
+	// Files can also be downloaded with API tokens, or through share links, so authentication is checked by requireStorageAccess
+	storage := dynamic.Append(app.authenticateToken, app.requireScope(models.ScopeRead), app.requireStorageAccess)
+	router.Handler(http.MethodGet, "/storage/*filepath", storage.Then(app.staticCacheHeaders(http.StripPrefix("/storage", storageServer))))
 
 	router.Handler(http.MethodGet, "/", protected.ThenFunc(app.homePage))
 	router.Handler(http.MethodGet, "/events/view/:id", protected.ThenFunc(app.eventPage))
 	router.Handler(http.MethodGet, "/photos/view/:file", protected.ThenFunc(app.photoPage))
-	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogout))
 	router.Handler(http.MethodPost, "/photos/download", protected.ThenFunc(app.photoDownload))
+	router.Handler(http.MethodGet, "/photos/list", protected.ThenFunc(app.photoList))
 	router.Handler(http.MethodGet, "/events/download/:id", protected.ThenFunc(app.eventDownload))
-
-	// ADMIN
-	admin := protected.Append(app.requireAdmin)
-	router.Handler(http.MethodGet, "/user/create", admin.ThenFunc(app.userCreatePage))
-	router.Handler(http.MethodPost, "/user/create", admin.ThenFunc(app.userCreatePost))
-	router.Handler(http.MethodGet, "/photos/upload", admin.ThenFunc(app.photoUploadPage))
-	router.Handler(http.MethodPost, "/photos/upload", admin.ThenFunc(app.photoUploadPost))
-	router.Handler(http.MethodPost, "/photos/delete", admin.ThenFunc(app.photoDelete))
-	router.Handler(http.MethodGet, "/events/create", admin.ThenFunc(app.eventsCreatePage))
-	router.Handler(http.MethodPost, "/events/create", admin.ThenFunc(app.eventsCreatePost))
-	router.Handler(http.MethodGet, "/events/update/:id", admin.ThenFunc(app.eventsUpdatePage))
-	router.Handler(http.MethodPost, "/events/update/:id", admin.ThenFunc(app.eventsUpdatePost))
-	router.Handler(http.MethodGet, "/events/delete", admin.ThenFunc(app.eventsDeletePage))
-	router.Handler(http.MethodPost, "/events/delete", admin.ThenFunc(app.eventsDeletePost))
+	router.Handler(http.MethodGet, "/user/favourites", protected.ThenFunc(app.favouritesPage))
+	router.Handler(http.MethodGet, "/user/account", protected.ThenFunc(app.accountPage))
+	router.Handler(http.MethodPost, "/user/account/name", protected.ThenFunc(app.accountNamePost))
+	router.Handler(http.MethodPost, "/user/account/password", protected.ThenFunc(app.accountPasswordPost))
+	router.Handler(http.MethodPost, "/user/account/sso", protected.ThenFunc(app.accountSSOLinkPost))
+	router.Handler(http.MethodPost, "/user/account/sso/unlink", protected.ThenFunc(app.accountSSOUnlinkPost))
+	router.Handler(http.MethodGet, "/user/sessions", protected.ThenFunc(app.sessionsPage))
+	router.Handler(http.MethodPost, "/user/sessions/revoke", protected.ThenFunc(app.sessionRevokePost))
+	router.Handler(http.MethodGet, "/user/tokens", protected.ThenFunc(app.tokensPage))
+	router.Handler(http.MethodPost, "/user/tokens/create", protected.ThenFunc(app.tokenCreatePost))
+	router.Handler(http.MethodPost, "/user/tokens/delete/:id", protected.ThenFunc(app.tokenDeletePost))
+	router.Handler(http.MethodPost, "/photos/favourite/:file", protected.ThenFunc(app.photoFavouriteToggle))
+	router.Handler(http.MethodPost, "/comments/create", protected.ThenFunc(app.commentCreatePost))
+	router.Handler(http.MethodPost, "/comments/update/:id", protected.ThenFunc(app.commentUpdatePost))
+	router.Handler(http.MethodPost, "/comments/delete/:id", protected.ThenFunc(app.commentDeletePost))
+	router.Handler(http.MethodGet, "/albums", protected.ThenFunc(app.albumsPage))
+	router.Handler(http.MethodGet, "/albums/view/:id", protected.ThenFunc(app.albumPage))
+	router.Handler(http.MethodGet, "/albums/download/:id", protected.ThenFunc(app.albumDownload))
+	router.Handler(http.MethodGet, "/timeline", protected.ThenFunc(app.timelinePage))
+	router.Handler(http.MethodGet, "/timeline/photos", protected.ThenFunc(app.timelinePhotos))
+	router.Handler(http.MethodGet, "/map", protected.ThenFunc(app.mapPage))
+	router.Handler(http.MethodGet, "/map/photos", protected.ThenFunc(app.mapPhotos))
+
+	// PERMISSION REQUIRED
+	permitted := func(permission string) alice.Chain {
+		return protected.Append(app.requirePermission(permission))
+	}
+	router.Handler(http.MethodGet, "/photos/upload", permitted(models.PermissionPhotosUpload).ThenFunc(app.photoUploadPage))
+	router.Handler(http.MethodPost, "/photos/upload", permitted(models.PermissionPhotosUpload).ThenFunc(app.photoUploadPost))
+	router.Handler(http.MethodGet, "/user/create", permitted(models.PermissionUsersManage).ThenFunc(app.userCreatePage))
+	router.Handler(http.MethodPost, "/user/create", permitted(models.PermissionUsersManage).ThenFunc(app.userCreatePost))
+	router.Handler(http.MethodGet, "/users", permitted(models.PermissionUsersManage).ThenFunc(app.usersPage))
+	router.Handler(http.MethodGet, "/users/update/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userUpdatePage))
+	router.Handler(http.MethodPost, "/users/update/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userUpdatePost))
+	router.Handler(http.MethodPost, "/users/password/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userPasswordResetPost))
+	router.Handler(http.MethodPost, "/users/unlock/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userUnlockPost))
+	router.Handler(http.MethodGet, "/users/sessions/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userSessionsPage))
+	router.Handler(http.MethodPost, "/users/sessions/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userSessionsRevokePost))
+	router.Handler(http.MethodPost, "/users/delete/:id", permitted(models.PermissionUsersManage).ThenFunc(app.userDeletePost))
+	router.Handler(http.MethodPost, "/users/2fa", permitted(models.PermissionUsersManage).ThenFunc(app.twoFactorSettingPost))
+	router.Handler(http.MethodGet, "/invitations", permitted(models.PermissionUsersManage).ThenFunc(app.invitationsPage))
+	router.Handler(http.MethodPost, "/invitations/create", permitted(models.PermissionUsersManage).ThenFunc(app.invitationCreatePost))
+	router.Handler(http.MethodPost, "/invitations/delete/:id", permitted(models.PermissionUsersManage).ThenFunc(app.invitationDeletePost))
+	router.Handler(http.MethodPost, "/photos/delete", permitted(models.PermissionPhotosDelete).ThenFunc(app.photoDelete))
+	router.Handler(http.MethodGet, "/events/create", permitted(models.PermissionEventsEdit).ThenFunc(app.eventsCreatePage))
+	router.Handler(http.MethodPost, "/events/create", permitted(models.PermissionEventsEdit).ThenFunc(app.eventsCreatePost))
+	router.Handler(http.MethodGet, "/events/update/:id", permitted(models.PermissionEventsEdit).ThenFunc(app.eventsUpdatePage))
+	router.Handler(http.MethodPost, "/events/update/:id", permitted(models.PermissionEventsEdit).ThenFunc(app.eventsUpdatePost))
+	router.Handler(http.MethodGet, "/events/delete", permitted(models.PermissionEventsDelete).ThenFunc(app.eventsDeletePage))
+	router.Handler(http.MethodPost, "/events/delete", permitted(models.PermissionEventsDelete).ThenFunc(app.eventsDeletePost))
+	router.Handler(http.MethodPost, "/events/highlights", permitted(models.PermissionEventsEdit).ThenFunc(app.eventsHighlightsPost))
+	router.Handler(http.MethodGet, "/events/permissions/:id", permitted(models.PermissionEventsAccess).ThenFunc(app.eventPermissionsPage))
+	router.Handler(http.MethodPost, "/events/permissions/:id", permitted(models.PermissionEventsAccess).ThenFunc(app.eventPermissionsPost))
+	router.Handler(http.MethodPost, "/permissions/delete/:id", permitted(models.PermissionEventsAccess).ThenFunc(app.eventPermissionDeletePost))
+	router.Handler(http.MethodGet, "/groups", permitted(models.PermissionUsersManage).ThenFunc(app.groupsPage))
+	router.Handler(http.MethodPost, "/groups/create", permitted(models.PermissionUsersManage).ThenFunc(app.groupCreatePost))
+	router.Handler(http.MethodPost, "/groups/delete/:id", permitted(models.PermissionUsersManage).ThenFunc(app.groupDeletePost))
+	router.Handler(http.MethodPost, "/groups/members/:id", permitted(models.PermissionUsersManage).ThenFunc(app.groupMembersPost))
+	router.Handler(http.MethodGet, "/events/geotag/:id", permitted(models.PermissionEventsEdit).ThenFunc(app.geotagPage))
+	router.Handler(http.MethodPost, "/events/geotag/:id", permitted(models.PermissionEventsEdit).ThenFunc(app.geotagPreviewPost))
+	router.Handler(http.MethodPost, "/events/locations/:id", permitted(models.PermissionEventsEdit).ThenFunc(app.geotagApplyPost))
+	router.Handler(http.MethodPost, "/comments/hide/:id", permitted(models.PermissionCommentsModerate).ThenFunc(app.commentHidePost))
+	router.Handler(http.MethodGet, "/albums/create", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumCreatePage))
+	router.Handler(http.MethodPost, "/albums/create", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumCreatePost))
+	router.Handler(http.MethodGet, "/albums/update/:id", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumUpdatePage))
+	router.Handler(http.MethodPost, "/albums/update/:id", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumUpdatePost))
+	router.Handler(http.MethodPost, "/albums/delete/:id", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumDeletePost))
+	router.Handler(http.MethodPost, "/albums/move/:id", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumMovePhoto))
+	router.Handler(http.MethodPost, "/albums/add", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumAddPhotos))
+	router.Handler(http.MethodPost, "/albums/remove", permitted(models.PermissionAlbumsEdit).ThenFunc(app.albumRemovePhotos))
+	router.Handler(http.MethodGet, "/shares", permitted(models.PermissionSharesManage).ThenFunc(app.sharesPage))
+	router.Handler(http.MethodGet, "/shares/create", permitted(models.PermissionSharesManage).ThenFunc(app.shareCreatePage))
+	router.Handler(http.MethodPost, "/shares/create", permitted(models.PermissionSharesManage).ThenFunc(app.shareCreatePost))
+	router.Handler(http.MethodPost, "/shares/delete/:id", permitted(models.PermissionSharesManage).ThenFunc(app.shareDeletePost))
+	router.Handler(http.MethodGet, "/audit", permitted(models.PermissionAuditView).ThenFunc(app.auditPage))
+	router.Handler(http.MethodGet, "/trash", permitted(models.PermissionTrashManage).ThenFunc(app.trashPage))
+	router.Handler(http.MethodPost, "/trash/restore/:id", permitted(models.PermissionTrashManage).ThenFunc(app.trashRestorePost))
+	router.Handler(http.MethodPost, "/trash/purge/:id", permitted(models.PermissionTrashManage).ThenFunc(app.trashPurgePost))
+
+	// API
+	// Tokens are checked before nosurf, since requests authenticated by them do not need the CSRF token
+	api := alice.New(app.SessionManager.LoadAndSave, app.authenticate, app.authenticateToken, app.noSurf, app.apiRequireTwoFactor)
+
+	apiProtected := api.Append(app.apiRequireAuthentication, app.requireScope(models.ScopeRead))
+	router.Handler(http.MethodGet, "/api/v1/events", apiProtected.ThenFunc(app.apiEventsList))
+	router.Handler(http.MethodGet, "/api/v1/events/:id", apiProtected.ThenFunc(app.apiEventShow))
+	router.Handler(http.MethodGet, "/api/v1/photos", apiProtected.ThenFunc(app.apiPhotosList))
+	router.Handler(http.MethodGet, "/api/v1/photos/:file", apiProtected.ThenFunc(app.apiPhotoShow))
+	router.Handler(http.MethodGet, "/api/v1/users/:id", apiProtected.ThenFunc(app.apiUserShow))
+
+	apiUpload := api.Append(app.apiRequirePermission(models.PermissionPhotosUpload), app.requireScope(models.ScopeUpload))
+	router.Handler(http.MethodPost, "/api/v1/photos", apiUpload.ThenFunc(app.apiPhotosCreate))
+
+	// Tokens need the admin scope for anything that requires a permission
+	apiPermitted := func(permission string) alice.Chain {
+		return api.Append(app.apiRequirePermission(permission), app.requireScope(models.ScopeAdmin))
+	}
+	router.Handler(http.MethodPost, "/api/v1/events", apiPermitted(models.PermissionEventsEdit).ThenFunc(app.apiEventCreate))
+	router.Handler(http.MethodPatch, "/api/v1/events/:id", apiPermitted(models.PermissionEventsEdit).ThenFunc(app.apiEventUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/events/:id", apiPermitted(models.PermissionEventsDelete).ThenFunc(app.apiEventDelete))
+	router.Handler(http.MethodPatch, "/api/v1/photos/:file", apiPermitted(models.PermissionPhotosDelete).ThenFunc(app.apiPhotoUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/photos/:file", apiPermitted(models.PermissionPhotosDelete).ThenFunc(app.apiPhotoDelete))
+	router.Handler(http.MethodGet, "/api/v1/users", apiPermitted(models.PermissionUsersManage).ThenFunc(app.apiUsersList))
+	router.Handler(http.MethodPost, "/api/v1/users", apiPermitted(models.PermissionUsersManage).ThenFunc(app.apiUserCreate))
 
 	standard := alice.New(app.recoverPanic, app.logRequest, app.secureHeaders)
 
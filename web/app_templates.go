@@ -4,14 +4,12 @@ import (
 	"html/template"
 	"io/fs"
 	"net/http"
-	"path"
 	"path/filepath"
 	"sitoWow/internal/data"
 	"sitoWow/internal/data/models"
 	"sitoWow/internal/validator"
 	"sitoWow/ui"
 	"slices"
-	"strings"
 	"time"
 
 	"github.com/justinas/nosurf"
@@ -22,20 +20,73 @@ type TemplateData struct {
 	Validator       *validator.Validator // use when you only need errors without a form (htmx)
 	Flash           string
 	IsAuthenticated bool
-	IsAdmin         bool
+	Role            string // Role of the authenticated user, empty if not authenticated
 	CSRFToken       string
 	Event           *models.Event
 	Events          []*models.Event
+	Breadcrumbs     []*models.Event // Ancestors of Event, top level first
+	Categories      []*EventCategory
 	Photo           *models.Photo
 	Photos          []*models.Photo
 	PhotosByEvent   map[int][]*models.Photo
+	Cover           *models.Photo
+	Highlights      []*models.Photo
+	Comments        []*models.Comment
+	Album           *models.Album
+	Albums          []*models.Album
 	Metadata        *data.Metadata
+	Timeline        []*TimelineDay
+	TimelineYears   []*TimelineYear
+	NextPage        string // Url of the next page, for infinite scrolling
+	Locations       []*PhotoLocation
+	User            *models.User
+	Users           []*models.User
+	LoginThrottles  map[string]*models.LoginThrottle // Failed logins of the users, by name
+	Tokens          []*models.Token
+	NewToken        string // Plaintext of the token just created
+	Permissions     []*models.EventPermission
+	InheritedFrom   *models.Event // Ancestor whose permissions apply to Event
+	Groups          []*models.Group
+	ShareLink       *models.ShareLink
+	ShareLinks      []*models.ShareLink
+	NewShareLink    string // Url of the share link just created
+
+	TwoFactorUsers        map[int]bool // Ids of the users that enabled the two factor
+	RequireAdminTwoFactor bool
+	TwoFactorEnabled      bool // Whether the authenticated user enabled the two factor
+	TOTPSecret            string
+	TOTPQRCode            template.URL // Data uri of the QR code of the secret being enrolled
+	RecoveryCodes         []string     // Plaintexts of the recovery codes just generated
+
+	SSOEnabled bool
+	Identities []*models.Identity
+
+	Invitation    *models.Invitation
+	Invitations   []*models.Invitation
+	NewInvitation string // Url of the invitation just created
+
+	Sessions       []*models.Session
+	CurrentSession string // ID of the session of the request
+
+	AuditEntries []*models.AuditEntry
+	PrevPage     string // Url of the previous page of the audit log
+
+	TrashItems         []*models.TrashItem
+	TrashRetentionDays int // Days after which items are purged, never if 0
+}
+
+// Whether the role of the authenticated user grants the permission, used in templates as {{if .Can "events:edit"}}
+func (td *TemplateData) Can(permission string) bool {
+	return models.RoleHas(td.Role, permission)
 }
 
 var functions = template.FuncMap{
 	"Add":     func(a, b int) int { return a + b },
 	"Modulo":  func(a, b, c int) bool { return a%b == c },
-	"isVideo": func(f string) bool { return slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(f))) },
+	"isVideo": isVideoFile,
+	"Contains": slices.Contains[[]string],
+	"Roles":   func() []string { return models.Roles },
+	"AuditActions": func() []string { return models.AuditActions },
 	"Day":     func(d time.Time) string { return d.Format(time.DateOnly) },
 	"DayWords": func(d time.Time) string { return d.Format("Monday, 02 January 2006") },
 	"Time": func(d time.Time) string { loc, _:= time.LoadLocation("Europe/Rome"); return d.In(loc).Format("15:04") },
@@ -73,7 +124,8 @@ func (app *Application) newTemplateData(r *http.Request) *TemplateData {
 	return &TemplateData{
 		Flash:           app.SessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.IsAuthenticated(r),
-		IsAdmin:         app.IsAdmin(r),
+		Role:            app.UserRole(r),
 		CSRFToken:       nosurf.Token(r),
+		SSOEnabled:      app.OIDC != nil,
 	}
 }
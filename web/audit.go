@@ -0,0 +1,176 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sitoWow/internal/data"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const auditPageSize = 50
+
+// Record an action of the authenticated user in the audit log
+func (app *Application) audit(r *http.Request, action, targetType string, targetID any, before, after any) {
+	app.auditAs(r, app.UserID(r), action, targetType, targetID, before, after)
+}
+
+// Record an action in the audit log, for when the actor is not the authenticated user, like at login.
+// The action already happened, so failures are only logged.
+// Before and after are stored as json, only their exported fields are kept
+func (app *Application) auditAs(r *http.Request, actor int, action, targetType string, targetID any, before, after any) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	entry := &models.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		RequestID:  requestId.String(),
+		IP:         app.clientIP(r),
+	}
+
+	if actor != 0 {
+		entry.ActorID = &actor
+	}
+
+	var err error
+
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+	}
+	if err == nil && after != nil {
+		entry.After, err = json.Marshal(after)
+	}
+	if err == nil {
+		err = app.Models.Audit.Insert(entry)
+	}
+
+	if err != nil {
+		app.Logger.Error("audit log failed",
+			"requestId", requestId,
+			"action", action,
+			"target", targetID,
+			"error", err.Error(),
+		)
+	}
+}
+
+// Audit log filters as sent in the query string, kept as strings to fill the filters form back
+type auditFiltersForm struct {
+	Action              string `form:"action"`
+	Actor               int    `form:"actor"`
+	From                string `form:"from"`
+	To                  string `form:"to"`
+	validator.Validator `form:"-"`
+}
+
+// Url of a page of the audit log with the same filters, empty if the page does not exist
+func (form *auditFiltersForm) pageURL(page int, metadata data.Metadata) string {
+	if page < metadata.FirstPage || page > metadata.LastPage || page == metadata.CurrentPage {
+		return ""
+	}
+
+	qs := url.Values{}
+
+	set := func(key, value string) {
+		if value != "" {
+			qs.Set(key, value)
+		}
+	}
+
+	set("action", form.Action)
+	set("from", form.From)
+	set("to", form.To)
+	if form.Actor != 0 {
+		qs.Set("actor", strconv.Itoa(form.Actor))
+	}
+	qs.Set("page", strconv.Itoa(page))
+
+	return "/audit?" + qs.Encode()
+}
+
+func (app *Application) auditPage(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	var form auditFiltersForm
+
+	form.Action = app.readString(qs, "action", "")
+	form.Actor = app.readInt(qs, "actor", 0, &form.Validator)
+	form.From = app.readString(qs, "from", "")
+	form.To = app.readString(qs, "to", "")
+
+	// Times come from datetime-local inputs, in the time zone the log is shown in
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		loc = time.UTC
+	}
+
+	parseTime := func(value, key string) *time.Time {
+		if value == "" {
+			return nil
+		}
+
+		t, err := time.ParseInLocation("2006-01-02T15:04", value, loc)
+		if err != nil {
+			form.AddFieldError(key, "must be a valid date and time")
+			return nil
+		}
+
+		return &t
+	}
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, &form.Validator),
+		PageSize:     auditPageSize,
+		Sort:         "-created_at",
+		SortSafelist: []string{"-created_at"},
+		From:         parseTime(form.From, "from"),
+		To:           parseTime(form.To, "to"),
+		Action:       form.Action,
+		Actor:        form.Actor,
+	}
+
+	form.CheckField(form.Action == "" || validator.PermittedValue(form.Action, models.AuditActions...), "action", "invalid action")
+	data.ValidateFilters(&form.Validator, filters)
+
+	// The inputs have minute precision, so the entries of the whole minute of to are included
+	if filters.To != nil {
+		to := filters.To.Add(time.Minute)
+		filters.To = &to
+	}
+
+	tdata := app.newTemplateData(r)
+	tdata.Form = form
+
+	tdata.Users, err = app.Models.Users.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "audit.tmpl", tdata)
+		return
+	}
+
+	var metadata data.Metadata
+
+	tdata.AuditEntries, metadata, err = app.Models.Audit.GetFiltered(filters)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.Metadata = &metadata
+	tdata.PrevPage = form.pageURL(metadata.CurrentPage-1, metadata)
+	tdata.NextPage = form.pageURL(metadata.CurrentPage+1, metadata)
+
+	app.render(w, r, http.StatusOK, "audit.tmpl", tdata)
+}
@@ -0,0 +1,287 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Arrange the comments of a photo in threads, and set what the current user can do with each one
+func (app *Application) commentThreads(r *http.Request, comments []*models.Comment) []*models.Comment {
+	userID := app.UserID(r)
+	canModerate := app.Can(r, models.PermissionCommentsModerate)
+
+	byID := make(map[int]*models.Comment)
+	for _, c := range comments {
+		c.CanEdit = c.Author == userID && !c.Deleted && !c.Hidden
+		c.CanHide = canModerate
+		byID[c.ID] = c
+	}
+
+	threads := []*models.Comment{}
+	for _, c := range comments {
+		if c.Parent == nil {
+			threads = append(threads, c)
+			continue
+		}
+
+		parent, ok := byID[*c.Parent]
+		if !ok {
+			// Should never happen thanks to db foreign key
+			threads = append(threads, c)
+			continue
+		}
+		parent.Replies = append(parent.Replies, c)
+	}
+
+	return threads
+}
+
+// Comment forms are sent by htmx, so redirects have to go through the HX-Redirect header
+func (app *Application) commentRedirect(w http.ResponseWriter, photoFile string, commentID int) {
+	target := fmt.Sprintf("/photos/view/%s", url.PathEscape(photoFile))
+	if commentID != 0 {
+		target = fmt.Sprintf("%s#comment-%d", target, commentID)
+	}
+
+	w.Header()["HX-Redirect"] = []string{target}
+}
+
+// The comment forms are not rendered again, so all their errors go in the flash, one per line
+func (app *Application) flashFormErrors(r *http.Request, v validator.Validator) {
+	errs := append(v.NonFieldErrors, slices.Sorted(maps.Values(v.FieldErrors))...)
+	app.SessionManager.Put(r.Context(), "flash", strings.Join(errs, "\n"))
+}
+
+type commentCreateForm struct {
+	Photo               string `form:"photo"`
+	Parent              int    `form:"parent"`
+	Body                string `form:"body"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) commentCreatePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form commentCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	photo, err := app.Models.Photos.GetByFile(form.Photo)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !app.checkEventAccess(w, r, photo.Event, models.AccessView) {
+		return
+	}
+
+	comment := &models.Comment{
+		Photo:  photo.ID,
+		Author: app.UserID(r),
+		Body:   form.Body,
+	}
+
+	if form.Parent != 0 {
+		parent, err := app.Models.Comments.GetByID(form.Parent)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		form.CheckField(err == nil && parent.Photo == photo.ID, "parent", "The comment you are replying to does not exist")
+		comment.Parent = &form.Parent
+	}
+
+	models.ValidateComment(&form.Validator, comment)
+
+	if !form.Valid() {
+		app.flashFormErrors(r, form.Validator)
+		app.commentRedirect(w, photo.FileName, form.Parent)
+		return
+	}
+
+	err = app.Models.Comments.Insert(comment)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("comment created",
+		"requestId", requestId,
+		"commentID", comment.ID,
+		"photoID", photo.ID,
+		"userId", comment.Author,
+	)
+
+	app.commentRedirect(w, photo.FileName, comment.ID)
+}
+
+// Retrieve the comment in the route, and check that the current user can view its event
+// and, if mustBeAuthor, that they are its author
+func (app *Application) commentFromParams(w http.ResponseWriter, r *http.Request, mustBeAuthor bool) (*models.Comment, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	comment, err := app.Models.Comments.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	// Access to the event may have been revoked after the comment was posted
+	if !app.checkEventAccess(w, r, comment.PhotoEvent, models.AccessView) {
+		return nil, false
+	}
+
+	if mustBeAuthor && (comment.Author != app.UserID(r) || comment.Deleted) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return comment, true
+}
+
+type commentUpdateForm struct {
+	Body                string `form:"body"`
+	Version             int    `form:"version"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) commentUpdatePost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentFromParams(w, r, true)
+	if !ok {
+		return
+	}
+
+	// Comments hidden by a moderator cannot be changed by their author
+	if comment.Hidden {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	var form commentUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	comment.Body = form.Body
+	comment.Version = form.Version
+
+	models.ValidateComment(&form.Validator, comment)
+
+	if !form.Valid() {
+		app.flashFormErrors(r, form.Validator)
+		app.commentRedirect(w, comment.PhotoFile, comment.ID)
+		return
+	}
+
+	err = app.Models.Comments.Update(comment)
+	if err != nil {
+		if errors.Is(err, models.ErrEditConflict) {
+			app.SessionManager.Put(r.Context(), "flash", "The comment was changed in the meantime, try again")
+			app.commentRedirect(w, comment.PhotoFile, comment.ID)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.commentRedirect(w, comment.PhotoFile, comment.ID)
+}
+
+func (app *Application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	comment, ok := app.commentFromParams(w, r, true)
+	if !ok {
+		return
+	}
+
+	err := app.Models.Comments.Delete(comment.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("comment deleted",
+		"requestId", requestId,
+		"commentID", comment.ID,
+		"userId", comment.Author,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Comment deleted successfully")
+	app.commentRedirect(w, comment.PhotoFile, 0)
+}
+
+// Hide the comment if it's visible, show it again otherwise
+func (app *Application) commentHidePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	comment, ok := app.commentFromParams(w, r, false)
+	if !ok {
+		return
+	}
+
+	err := app.Models.Comments.SetHidden(comment.ID, !comment.Hidden)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("comment moderated",
+		"requestId", requestId,
+		"commentID", comment.ID,
+		"hidden", !comment.Hidden,
+		"userId", app.UserID(r),
+	)
+
+	app.commentRedirect(w, comment.PhotoFile, comment.ID)
+}
@@ -5,12 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path"
+	"sitoWow/internal/data"
 	"sitoWow/internal/data/models"
 	"sitoWow/internal/validator"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -41,19 +42,108 @@ func (app *Application) eventPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	photos, err := app.Models.Photos.GetAll(&event.ID)
+	if !app.checkEventAccess(w, r, event.ID, models.AccessView) {
+		return
+	}
+
+	// Invalid filters are shown in the form and ignored
+	filtersForm, filters := app.readPhotoFilters(r.URL.Query())
+	if !filtersForm.Valid() {
+		filters = data.Filters{PageSize: photoListPageSize}
+	}
+
+	// Only the first page of photos, the others are loaded by htmx while scrolling
+	filters.Cursor = nil
+	photos, metadata, err := app.Models.Photos.GetFiltered(&event.ID, filters)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Uploaders that can be chosen in the filters
+	tdata.Users, err = app.Models.Photos.GetUploaders(event.ID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	tdata.Form = filtersForm
+
+	app.setThumbNames(photos)
 
-	// Set thubnail names, replace video extensions with jpg extension (for thumbnail path)
-	for i := range photos {
-		if slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(photos[i].FileName))) {
-			// Thumbnail for video is video filename(with extension)+".jpg"
-			photos[i].ThumbName = fmt.Sprintf("%s%s", path.Base(photos[i].FileName), ".jpg")
-		} else {
-			photos[i].ThumbName = photos[i].FileName
+	err = app.setFavourites(r, event.ID, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Cover and highlights chosen by admins, without a cover none is shown since the first
+	// photo is already at the top of the list. They are retrieved separately since they may not be in the first page
+	chosenIDs := event.Highlights
+	if event.Cover != nil {
+		chosenIDs = append([]int{*event.Cover}, chosenIDs...)
+	}
+
+	byID := make(map[int]*models.Photo)
+	if len(chosenIDs) > 0 {
+		chosen, err := app.Models.Photos.GetByIDs(chosenIDs)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.setThumbNames(chosen)
+
+		for _, p := range chosen {
+			// Photos moved to other events are not shown
+			if p.Event == event.ID {
+				byID[p.ID] = p
+			}
+		}
+	}
+
+	if event.Cover != nil {
+		tdata.Cover = byID[*event.Cover]
+	}
+
+	for _, id := range event.Highlights {
+		if p, ok := byID[id]; ok {
+			tdata.Highlights = append(tdata.Highlights, p)
+		}
+	}
+
+	tdata.NextPage = photoListURL(event.ID, filtersForm.query(), metadata.NextCursor)
+
+	// Album editors can add selected photos to albums
+	if tdata.Can(models.PermissionAlbumsEdit) {
+		tdata.Albums, err = app.Models.Albums.GetAll()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	// Position of the event in the hierarchy
+	tdata.Breadcrumbs, err = app.Models.Events.GetAncestors(event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	descendants, err := app.Models.Events.GetDescendants(event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	descendants, err = app.accessibleEvents(r, descendants)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, d := range descendants {
+		if d.Parent != nil && *d.Parent == event.ID {
+			tdata.Events = append(tdata.Events, d)
 		}
 	}
 
@@ -82,51 +172,208 @@ func (app *Application) eventPage(w http.ResponseWriter, r *http.Request) {
 //}
 
 type eventCreateForm struct {
-	Name                string     `form:"name"`
-	Date                *time.Time `form:"-"`
+	Name                string `form:"name"`
+	Description         string `form:"description"`
+	Date                string `form:"date"`
+	EndDate             string `form:"end_date"`
+	Location            string `form:"location"`
+	Latitude            string `form:"latitude"`
+	Longitude           string `form:"longitude"`
+	Parent              int    `form:"parent"`
+	Category            string `form:"category"`
+	Version             int    `form:"version"`
 	validator.Validator `form:"-"`
 }
 
+func newEventCreateForm(event *models.Event) eventCreateForm {
+	form := eventCreateForm{
+		Name:        event.Name,
+		Description: event.Description,
+		Location:    event.Location,
+		Category:    event.Category,
+		Version:     event.Version,
+	}
+
+	if event.Parent != nil {
+		form.Parent = *event.Parent
+	}
+
+	if event.Date != nil {
+		form.Date = event.Date.Format(time.DateOnly)
+	}
+	if event.EndDate != nil {
+		form.EndDate = event.EndDate.Format(time.DateOnly)
+	}
+	if event.Latitude != nil && event.Longitude != nil {
+		form.Latitude = strconv.FormatFloat(float64(*event.Latitude), 'f', -1, 32)
+		form.Longitude = strconv.FormatFloat(float64(*event.Longitude), 'f', -1, 32)
+	}
+
+	return form
+}
+
+// Copy the form values in the event, adding errors for the ones that cannot be parsed.
+// Dates are parsed manually since the form decoder does not handle them
+func (form *eventCreateForm) setEvent(event *models.Event) {
+	parseDate := func(value, key string) *time.Time {
+		if value == "" {
+			return nil
+		}
+
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil || date.IsZero() {
+			form.AddFieldError(key, "Date must be valid and non zero")
+			return nil
+		}
+
+		return &date
+	}
+
+	parseCoord := func(value, key string) *float32 {
+		if value == "" {
+			return nil
+		}
+
+		coord, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			form.AddFieldError(key, "Must be a decimal number")
+			return nil
+		}
+
+		c := float32(coord)
+		return &c
+	}
+
+	event.Name = form.Name
+	event.Description = form.Description
+	event.Date = parseDate(form.Date, "date")
+	event.EndDate = parseDate(form.EndDate, "end_date")
+	event.Location = form.Location
+	event.Latitude = parseCoord(form.Latitude, "latitude")
+	event.Longitude = parseCoord(form.Longitude, "longitude")
+	event.Category = form.Category
+
+	event.Parent = nil
+	if form.Parent != 0 {
+		event.Parent = &form.Parent
+	}
+
+	models.ValidateEvent(&form.Validator, event)
+}
+
+// Events that can be chosen as parent of an event: the ones the user can contribute to, since
+// the event inherits their permissions, and its current parent. The event itself and its
+// descendants are left out, they would create a cycle. A nil event means it has yet to be created
+func (app *Application) eventParentOptions(r *http.Request, event *models.Event) ([]*models.Event, error) {
+	events, err := app.Models.Events.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[int]bool)
+	current := 0
+
+	if event != nil {
+		descendants, err := app.Models.Events.GetDescendants(event.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		excluded[event.ID] = true
+		for _, d := range descendants {
+			excluded[d.ID] = true
+		}
+
+		if event.Parent != nil {
+			current = *event.Parent
+		}
+	}
+
+	options := []*models.Event{}
+	for _, e := range events {
+		if !excluded[e.ID] && (e.ID == current || hasAccess(access[e.ID], models.AccessContribute)) {
+			options = append(options, e)
+		}
+	}
+
+	return options, nil
+}
+
+// Moving an event out of its parent changes the permissions it inherits, so the user must be
+// able to contribute to the parent it leaves too. The new one is checked by checkParent
+func (app *Application) checkParentChange(r *http.Request, form *eventCreateForm, before *models.Event) error {
+	if before.Parent == nil || *before.Parent == form.Parent {
+		return nil
+	}
+
+	access, err := app.eventAccess(r, *before.Parent)
+	if err != nil {
+		return err
+	}
+
+	form.CheckField(hasAccess(access, models.AccessContribute), "parent", "You cannot move the event out of its current parent")
+
+	return nil
+}
+
+// Check that the chosen parent is one of the allowed options
+func (form *eventCreateForm) checkParent(options []*models.Event) {
+	if form.Parent == 0 {
+		return
+	}
+
+	found := false
+	for _, e := range options {
+		if e.ID == form.Parent {
+			found = true
+			break
+		}
+	}
+
+	form.CheckField(found, "parent", "The parent must be an event you can contribute to, and cannot be one of its sub-events")
+}
+
 func (app *Application) eventsCreatePage(w http.ResponseWriter, r *http.Request) {
+	options, err := app.eventParentOptions(r, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Form = eventCreateForm{}
+	data.Events = options
 	app.render(w, r, http.StatusOK, "eventCreate.tmpl", data)
 }
 
 func (app *Application) eventsCreatePost(w http.ResponseWriter, r *http.Request) {
 	var form eventCreateForm
 
-	// TODO perche non va in errore se la data non e' giusta???
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// shouldnt be manual, should use UnmarshalJSON but it doesnt work
-	if r.FormValue("date") != "" {
-		timeDate, err := time.Parse(time.DateOnly, r.FormValue("date"))
-		if err != nil {
-			app.clientError(w, http.StatusBadRequest)
-			return
-		}
-
-		form.Date = &timeDate
-	}
-
-	event := &models.Event{
-		Name: form.Name,
-		Date: form.Date,
+	options, err := app.eventParentOptions(r, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
 
-	form.CheckField(event.Name != "", "name", "This field must not be empty")
-	form.CheckField(r.FormValue("date") == "" || !form.Date.IsZero(), "date", "Date must be non zero")
-	// Try to prevent path traversal attacks
-	form.CheckField(!strings.Contains(event.Name, ".."), "name", "This field must not contain the string '..'")
+	event := &models.Event{}
+	form.setEvent(event)
+	form.checkParent(options)
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
+		data.Events = options
 		app.render(w, r, http.StatusUnprocessableEntity, "eventCreate.tmpl", data)
 		return
 	}
@@ -137,6 +384,8 @@ func (app *Application) eventsCreatePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	app.audit(r, models.AuditEventCreate, "event", event.ID, nil, event)
+
 	app.SessionManager.Put(r.Context(), "flash", "Event created successfully")
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -162,12 +411,20 @@ func (app *Application) eventsUpdatePage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	data := app.newTemplateData(r)
-	data.Form = eventCreateForm{
-		Name: event.Name,
-		Date: event.Date,
+	if !app.checkEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
 	}
+
+	options, err := app.eventParentOptions(r, event)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = newEventCreateForm(event)
 	data.Event = event
+	data.Events = options
 	app.render(w, r, http.StatusOK, "eventUpdate.tmpl", data)
 }
 
@@ -191,37 +448,44 @@ func (app *Application) eventsUpdatePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !app.checkEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
+	}
+
+	before := *event
+
 	var form eventCreateForm
 
-	// TODO perche non va in errore se la data non e' giusta???
 	err = app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// shouldnt be manual, should use UnmarshalJSON but it doesnt work
-	if r.FormValue("date") != "" {
-		timeDate, err := time.Parse(time.DateOnly, r.FormValue("date"))
-		if err != nil {
-			app.clientError(w, http.StatusBadRequest)
-			return
-		}
-
-		form.Date = &timeDate
+	// Use the version the form was created from, so that concurrent updates are detected
+	if form.Version != 0 {
+		event.Version = form.Version
+	}
+	options, err := app.eventParentOptions(r, event)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
 
-	event.Name = form.Name
-	event.Date = form.Date
+	form.setEvent(event)
+	form.checkParent(options)
 
-	form.CheckField(event.Name != "", "name", "This field must not be empty")
-	form.CheckField(r.FormValue("date") == "" || !form.Date.IsZero(), "date", "Date must be non zero")
-	// Try to prevent path traversal attacks
-	form.CheckField(!strings.Contains(event.Name, ".."), "name", "This field must not contain the string '..'")
+	err = app.checkParentChange(r, &form, &before)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
+		data.Event = event
+		data.Events = options
 		app.render(w, r, http.StatusUnprocessableEntity, "eventUpdate.tmpl", data)
 		return
 	}
@@ -237,11 +501,98 @@ func (app *Application) eventsUpdatePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	app.audit(r, models.AuditEventUpdate, "event", event.ID, before, event)
+
 	app.SessionManager.Put(r.Context(), "flash", "Event updated successfully")
 
 	http.Redirect(w, r, fmt.Sprintf("/events/view/%d", event.ID), http.StatusSeeOther)
 }
 
+// Set the cover and/or the highlights of an event. Fields that are not sent are left unchanged,
+// an empty cover or empty highlights reset them
+func (app *Application) eventsHighlightsPost(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token      string    `json:"csrf_token"` // only needed by readJSON since it checks for unknown keys
+		Event      int       `json:"event"`
+		Cover      *string   `json:"cover"`
+		Highlights *[]string `json:"highlights"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	event, err := app.Models.Events.GetByID(input.Event)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !app.checkEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
+	}
+
+	before := *event
+
+	photos, err := app.Models.Photos.GetAll(&event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only photos of the event can be chosen
+	byFile := make(map[string]int)
+	for _, p := range photos {
+		byFile[p.FileName] = p.ID
+	}
+
+	if input.Cover != nil {
+		event.Cover = nil
+		if *input.Cover != "" {
+			id, ok := byFile[photoFilesFromThumbs([]string{*input.Cover})[0]]
+			if !ok {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+			event.Cover = &id
+		}
+	}
+
+	if input.Highlights != nil {
+		event.Highlights = []int{}
+		for _, file := range photoFilesFromThumbs(*input.Highlights) {
+			id, ok := byFile[file]
+			if !ok {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+			event.Highlights = append(event.Highlights, id)
+		}
+	}
+
+	err = app.Models.Events.Update(event)
+	if err != nil {
+		if errors.Is(err, models.ErrEditConflict) {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditEventUpdate, "event", event.ID, before, event)
+
+	app.SessionManager.Put(r.Context(), "flash", "Event updated successfully")
+}
+
 type eventDeleteForm struct {
 	Event               int `form:"event"`
 	validator.Validator `form:"-"`
@@ -292,51 +643,78 @@ func (app *Application) eventsDeletePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Delete event
-	err = app.Models.Events.Delete(form.Event)
+	// Kept for the audit log
+	event, err := app.Models.Events.GetByID(form.Event)
+	if err == nil {
+		err = app.deleteEvent(event.ID, app.UserID(r))
+	}
 	if err != nil {
-		if errors.Is(err, models.ErrRecordNotFound) {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
 			// Render page again, with errors
 			form.AddFieldError("event", "Event not found")
 			app.renderEventDeleteErrors(w, r, form)
-			return
+		case errors.Is(err, errInvalidEventDir):
+			form.AddFieldError("event", "Event is not valid")
+			app.renderEventDeleteErrors(w, r, form)
+		default:
+			app.serverError(w, r, err)
 		}
-
-		app.serverError(w, r, err)
 		return
 	}
 
-	photoPath := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(form.Event))
+	app.audit(r, models.AuditEventDelete, "event", event.ID, event, nil)
+
+	app.SessionManager.Put(r.Context(), "flash", "Event moved to the trash, an admin can restore it")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Move the event along with its photos' files and thumbnails to the trash
+func (app *Application) deleteEvent(id int, deletedBy int) error {
+	photoPath := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(id))
 	// Prevent path traversal
 	if !app.InAllowedPath(photoPath, path.Join(app.Config.StorageDir, "photos")) {
-		form.AddFieldError("event", "Event is not valid")
-		app.renderEventDeleteErrors(w, r, form)
-		return
+		return errInvalidEventDir
 	}
 
-	thumbPath := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(form.Event))
+	thumbPath := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(id))
 	// Prevent path traversal
 	if !app.InAllowedPath(thumbPath, path.Join(app.Config.StorageDir, "thumbnails")) {
-		form.AddFieldError("event", "Event is not valid")
-		app.renderEventDeleteErrors(w, r, form)
-		return
+		return errInvalidEventDir
 	}
 
-	err = os.RemoveAll(thumbPath)
-	if err != nil {
-		app.serverError(w, r, err)
-		//return
-	}
+	// The files are moved before the records are committed, and moved back if they cannot be
+	var itemID int
+	var moved []movedFile
+
+	_, err := app.Models.Trash.TrashEvent(id, deletedBy, func(item int) error {
+		itemID = item
+		trashPhotosDir, trashThumbsDir := app.trashDirs(item)
+
+		var err error
+		moved, err = moveFiles(thumbPath, trashThumbsDir, nil)
+		if err != nil {
+			return err
+		}
 
-	err = os.RemoveAll(photoPath)
+		photos, err := moveFiles(photoPath, trashPhotosDir, nil)
+		moved = append(moved, photos...)
+		return err
+	})
 	if err != nil {
-		app.serverError(w, r, err)
-		//return
+		return app.undoTrashMoves(err, itemID, moved)
 	}
 
-	app.SessionManager.Put(r.Context(), "flash", "Event deleted successfully")
+	// The directories are empty now
+	for _, dir := range []string{thumbPath, photoPath} {
+		err = os.Remove(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
 }
 
 func (app *Application) eventDownload(w http.ResponseWriter, r *http.Request) {
@@ -359,8 +737,60 @@ func (app *Application) eventDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.checkEventAccess(w, r, event.ID, models.AccessView) {
+		return
+	}
+
 	photos, err := app.Models.Photos.GetAll(&event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// With ?children=true the photos of the sub-events are included too,
+	// each sub-event in its own folder
+	if r.URL.Query().Get("children") != "true" {
+		app.sendPhotosZip(w, r, event.Name, photos, nil)
+		return
+	}
+
+	descendants, err := app.Models.Events.GetDescendants(event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Descendants are ordered by depth, so parents' folders are always set before their children's
+	folders := map[int]string{event.ID: ""}
+	for _, d := range descendants {
+		folders[d.ID] = path.Join(folders[*d.Parent], strings.ReplaceAll(d.Name, "/", "_"))
+
+		// The folders of sub-events that cannot be viewed are still needed by their children
+		if !hasAccess(access[d.ID], models.AccessView) {
+			continue
+		}
+
+		children, err := app.Models.Photos.GetAll(&d.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		photos = append(photos, children...)
+	}
+
+	app.sendPhotosZip(w, r, event.Name, photos, folders)
+}
 
+// Send the photos' original files to the client as a zip archive called name.zip.
+// If folders is not nil, photos are put in the folder of their event
+func (app *Application) sendPhotosZip(w http.ResponseWriter, r *http.Request, name string, photos []*models.Photo, folders map[int]string) {
 	// ---- Zip files
 	tmpDir := path.Join(app.Config.StorageDir, "tmp")
 	tmpPath := path.Join(tmpDir, uuid.NewString())
@@ -390,7 +820,7 @@ func (app *Application) eventDownload(w http.ResponseWriter, r *http.Request) {
 
 	// Add photos to zip
 	for _, photo := range photos {
-		photoPath := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(event.ID), photo.FileName)
+		photoPath := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(photo.Event), photo.FileName)
 		// Prevent path traversal
 		if !app.InAllowedPath(photoPath, path.Join(app.Config.StorageDir, "photos")) {
 			app.clientError(w, http.StatusBadRequest)
@@ -404,7 +834,7 @@ func (app *Application) eventDownload(w http.ResponseWriter, r *http.Request) {
 		}
 		defer f.Close()
 
-		zw, err := zipWriter.Create(photo.FileName)
+		zw, err := zipWriter.Create(path.Join(folders[photo.Event], photo.FileName))
 		if err != nil {
 			app.serverError(w, r, err)
 			return
@@ -424,7 +854,7 @@ func (app *Application) eventDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", event.Name))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
 
 	// There is probably a better way
 	file, err := os.ReadFile(tmpPath)
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"sitoWow/internal/data/models"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *Application) favouritesPage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+
+	photos, err := app.Models.Favourites.GetAll(app.UserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Photos of events the user cannot view are hidden
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.setThumbNames(photos)
+
+	tdata.Photos = photos
+
+	app.render(w, r, http.StatusOK, "favourites.tmpl", tdata)
+}
+
+// Toggle favourite status of a photo for the current user.
+// Responds with the updated favourite button, for htmx to swap
+func (app *Application) photoFavouriteToggle(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	photo, err := app.Models.Photos.GetByFile(params.ByName("file"))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !app.checkEventAccess(w, r, photo.Event, models.AccessView) {
+		return
+	}
+
+	_, err = app.Models.Favourites.Toggle(app.UserID(r), photo.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	photo.IsFavourite, photo.Favourites, err = app.Models.Favourites.Status(app.UserID(r), photo.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only those who can edit events get to see the favourites count
+	if !app.Can(r, models.PermissionEventsEdit) {
+		photo.Favourites = 0
+	}
+
+	tdata := app.newTemplateData(r)
+	tdata.Photo = photo
+
+	app.renderRaw(w, r, http.StatusOK, "favouriteButton.tmpl", tdata)
+}
@@ -0,0 +1,232 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/geotag"
+	"sitoWow/internal/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Location proposed for a photo by a track
+type PhotoLocation struct {
+	Photo     *models.Photo
+	Latitude  float32
+	Longitude float32
+}
+
+type geotagForm struct {
+	Offset              int  `form:"offset"`  // Minutes to add to the photos' time to get the track's time
+	MaxGap              int  `form:"max_gap"` // Minutes between track points after which there is no interpolation
+	Overwrite           bool `form:"overwrite"`
+	validator.Validator `form:"-"`
+}
+
+// Retrieve the event with the id in the route
+func (app *Application) eventFromParams(w http.ResponseWriter, r *http.Request) (*models.Event, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	event, err := app.Models.Events.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return event, true
+}
+
+func (app *Application) geotagPage(w http.ResponseWriter, r *http.Request) {
+	event, ok := app.eventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
+	}
+
+	tdata := app.newTemplateData(r)
+	tdata.Event = event
+	tdata.Form = geotagForm{MaxGap: 10}
+	app.render(w, r, http.StatusOK, "geotag.tmpl", tdata)
+}
+
+// Match the event's photos to the uploaded track and show the proposed locations,
+// nothing is saved until they are confirmed
+func (app *Application) geotagPreviewPost(w http.ResponseWriter, r *http.Request) {
+	event, ok := app.eventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
+	}
+
+	err := r.ParseMultipartForm(32 << 20) // 32MB
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var form geotagForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(form.MaxGap > 0, "max_gap", "Must be greater than zero")
+
+	var track geotag.Track
+
+	file, header, err := r.FormFile("track")
+	if err != nil {
+		form.AddFieldError("track", "You must select a GPX or KML file")
+	} else {
+		defer file.Close()
+
+		track, err = geotag.Parse(header.Filename, file)
+		if err != nil {
+			form.AddFieldError("track", err.Error())
+		}
+	}
+
+	tdata := app.newTemplateData(r)
+	tdata.Event = event
+
+	if !form.Valid() {
+		tdata.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "geotag.tmpl", tdata)
+		return
+	}
+
+	photos, err := app.Models.Photos.GetAll(&event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.setThumbNames(photos)
+
+	offset := time.Duration(form.Offset) * time.Minute
+	maxGap := time.Duration(form.MaxGap) * time.Minute
+
+	for _, p := range photos {
+		if p.TakenAt == nil || (p.Latitude != nil && !form.Overwrite) {
+			continue
+		}
+
+		point, ok := track.Locate(p.TakenAt.Add(offset), maxGap)
+		if !ok {
+			continue
+		}
+
+		tdata.Locations = append(tdata.Locations, &PhotoLocation{
+			Photo:     p,
+			Latitude:  float32(point.Latitude),
+			Longitude: float32(point.Longitude),
+		})
+	}
+
+	if len(tdata.Locations) == 0 {
+		form.AddNonFieldError("No photo could be matched to the track, try changing the time offset")
+	}
+
+	tdata.Form = form
+	app.render(w, r, http.StatusOK, "geotag.tmpl", tdata)
+}
+
+type geotagApplyForm struct {
+	Photos     []int     `form:"photo"`
+	Latitudes  []float32 `form:"latitude"`
+	Longitudes []float32 `form:"longitude"`
+}
+
+// Save the locations confirmed in the preview
+func (app *Application) geotagApplyPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	event, ok := app.eventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkEventAccess(w, r, event.ID, models.AccessContribute) {
+		return
+	}
+
+	var form geotagApplyForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil || len(form.Photos) != len(form.Latitudes) || len(form.Photos) != len(form.Longitudes) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	photos, err := app.Models.Photos.GetAll(&event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only photos of the event can be changed
+	inEvent := make(map[int]bool)
+	for _, p := range photos {
+		inEvent[p.ID] = true
+	}
+
+	located := []*models.Photo{}
+	for i, id := range form.Photos {
+		if !inEvent[id] {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		located = append(located, &models.Photo{
+			ID:        id,
+			Latitude:  &form.Latitudes[i],
+			Longitude: &form.Longitudes[i],
+		})
+	}
+
+	err = app.Models.Photos.SetLocations(located)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidLatLon) || errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("photos geotagged",
+		"requestId", requestId,
+		"eventID", event.ID,
+		"photos", len(located),
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("Location set for %d photos", len(located)))
+
+	http.Redirect(w, r, fmt.Sprintf("/events/view/%d", event.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+type groupCreateForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderGroupsPage(w http.ResponseWriter, r *http.Request, status int, form groupCreateForm) {
+	tdata := app.newTemplateData(r)
+
+	var err error
+	tdata.Groups, err = app.Models.Groups.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.Users, err = app.Models.Users.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.Form = form
+	app.render(w, r, status, "groups.tmpl", tdata)
+}
+
+func (app *Application) groupsPage(w http.ResponseWriter, r *http.Request) {
+	app.renderGroupsPage(w, r, http.StatusOK, groupCreateForm{})
+}
+
+func (app *Application) groupCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form groupCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	group := &models.Group{Name: form.Name}
+
+	models.ValidateGroup(&form.Validator, group)
+	if !form.Valid() {
+		app.renderGroupsPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.Groups.Insert(group)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateName) {
+			form.AddFieldError("name", "Name is already in use")
+			app.renderGroupsPage(w, r, http.StatusUnprocessableEntity, form)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", "Group created successfully")
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+func (app *Application) groupDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	// Permissions given to the group are deleted too
+	err = app.Models.Groups.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("group deleted",
+		"requestId", requestId,
+		"groupID", id,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Group deleted successfully")
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+// Add (or with ?remove=true remove) the user in the form to the group
+func (app *Application) groupMembersPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	var form struct {
+		User int `form:"user"`
+	}
+
+	err = app.decodePostForm(r, &form)
+	if err != nil || form.User == 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("remove") == "true" {
+		err = app.Models.Groups.RemoveMember(id, form.User)
+	} else {
+		err = app.Models.Groups.AddMember(id, form.User)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", "Group members updated successfully")
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
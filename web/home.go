@@ -1,12 +1,8 @@
 package web
 
 import (
-	"fmt"
 	"net/http"
-	"path"
 	"sitoWow/internal/data/models"
-	"slices"
-	"strings"
 )
 
 func (app *Application) homePage(w http.ResponseWriter, r *http.Request) {
@@ -17,6 +13,13 @@ func (app *Application) homePage(w http.ResponseWriter, r *http.Request) {
 		app.serverError(w, r, err)
 		return
 	}
+
+	// Events the user cannot view are hidden, along with their photos
+	events, err = app.accessibleEvents(r, events)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	tdata.Events = events
 
 	photos, err := app.Models.Photos.Summary(10)
@@ -27,17 +30,81 @@ func (app *Application) homePage(w http.ResponseWriter, r *http.Request) {
 
 	tdata.PhotosByEvent = make(map[int][]*models.Photo)
 
-	// Since they are already ordered, they will remain ordered
-	for i, p := range photos {
-		if slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(photos[i].FileName))) {
-			// Thumbnail for video is video filename(with extension)+".jpg"
-			photos[i].ThumbName = fmt.Sprintf("%s%s", path.Base(photos[i].FileName), ".jpg")
-		} else {
-			photos[i].ThumbName = photos[i].FileName
-		}
+	app.setThumbNames(photos)
 
+	// Since they are already ordered, they will remain ordered
+	for _, p := range photos {
 		tdata.PhotosByEvent[p.Event] = append(tdata.PhotosByEvent[p.Event], p)
 	}
 
+	tdata.Categories = eventTree(events, tdata.PhotosByEvent)
+
 	app.render(w, r, http.StatusOK, "home.tmpl", tdata)
 }
+
+// An event in the home page tree, along with its summary photos and its sub-events
+type EventNode struct {
+	Event    *models.Event
+	Photos   []*models.Photo
+	Children []*EventNode
+}
+
+// Top level events grouped by category. Uncategorized events have an empty name
+type EventCategory struct {
+	Name   string
+	Events []*EventNode
+}
+
+// Arrange the events in a tree, dropping the branches that contain no photos.
+// Order of events and categories follows the order of the events list
+func eventTree(events []*models.Event, photosByEvent map[int][]*models.Photo) []*EventCategory {
+	nodes := make(map[int]*EventNode)
+	for _, e := range events {
+		nodes[e.ID] = &EventNode{Event: e, Photos: photosByEvent[e.ID]}
+	}
+
+	roots := []*EventNode{}
+	for _, e := range events {
+		var parent *EventNode
+		if e.Parent != nil {
+			parent = nodes[*e.Parent]
+		}
+
+		if parent == nil {
+			roots = append(roots, nodes[e.ID])
+			continue
+		}
+		parent.Children = append(parent.Children, nodes[e.ID])
+	}
+
+	categories := []*EventCategory{}
+	byName := make(map[string]*EventCategory)
+	for _, n := range roots {
+		if !n.prune() {
+			continue
+		}
+
+		c, ok := byName[n.Event.Category]
+		if !ok {
+			c = &EventCategory{Name: n.Event.Category}
+			byName[c.Name] = c
+			categories = append(categories, c)
+		}
+		c.Events = append(c.Events, n)
+	}
+
+	return categories
+}
+
+// Remove the children without photos in their subtree, and report whether the node has any
+func (n *EventNode) prune() bool {
+	children := []*EventNode{}
+	for _, c := range n.Children {
+		if c.prune() {
+			children = append(children, c)
+		}
+	}
+	n.Children = children
+
+	return len(n.Photos) > 0 || len(n.Children) > 0
+}
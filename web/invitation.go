@@ -0,0 +1,228 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+type invitationCreateForm struct {
+	Role                string `form:"role"`
+	ExpiryDays          int    `form:"expiry_days"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderInvitationsPage(w http.ResponseWriter, r *http.Request, status int, form invitationCreateForm) {
+	tdata := app.newTemplateData(r)
+	tdata.Form = form
+
+	var err error
+	tdata.Invitations, err = app.Models.Invitations.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The url is only shown once, right after the invitation is created
+	tdata.NewInvitation = app.SessionManager.PopString(r.Context(), "newInvitation")
+	app.render(w, r, status, "invitations.tmpl", tdata)
+}
+
+func (app *Application) invitationsPage(w http.ResponseWriter, r *http.Request) {
+	form := invitationCreateForm{
+		Role:       models.RoleViewer,
+		ExpiryDays: 7,
+	}
+
+	app.renderInvitationsPage(w, r, http.StatusOK, form)
+}
+
+func (app *Application) invitationCreatePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form invitationCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(form.ExpiryDays >= 1 && form.ExpiryDays <= 90, "expiry_days", "Expiry must be between 1 and 90 days")
+
+	invitation, err := models.GenerateInvitation(form.Role, app.UserID(r), time.Now().AddDate(0, 0, form.ExpiryDays))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	models.ValidateInvitation(&form.Validator, invitation)
+
+	if !form.Valid() {
+		app.renderInvitationsPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.Invitations.Insert(invitation)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("invitation created",
+		"requestId", requestId,
+		"invitationID", invitation.ID,
+		"role", invitation.Role,
+	)
+
+	app.SessionManager.Put(r.Context(), "newInvitation", fmt.Sprintf("https://%s/invite/%s", r.Host, invitation.Plaintext))
+	app.SessionManager.Put(r.Context(), "flash", "Invitation created successfully, copy the link now since it will not be shown again")
+
+	http.Redirect(w, r, "/invitations", http.StatusSeeOther)
+}
+
+func (app *Application) invitationDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.Models.Invitations.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("invitation revoked",
+		"requestId", requestId,
+		"invitationID", id,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Invitation revoked successfully")
+
+	http.Redirect(w, r, "/invitations", http.StatusSeeOther)
+}
+
+func (app *Application) invitationFromParams(w http.ResponseWriter, r *http.Request) (*models.Invitation, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	invitation, err := app.Models.Invitations.GetByToken(params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return invitation, true
+}
+
+type invitationAcceptForm struct {
+	Name                 string `form:"name"`
+	Password             string `form:"password"`
+	PasswordConfirmation string `form:"password_confirmation"`
+	validator.Validator  `form:"-"`
+}
+
+func (app *Application) renderInvitationPage(w http.ResponseWriter, r *http.Request, status int, invitation *models.Invitation, form invitationAcceptForm) {
+	tdata := app.newTemplateData(r)
+	tdata.Invitation = invitation
+	tdata.Form = form
+	app.render(w, r, status, "invitation.tmpl", tdata)
+}
+
+// Page where whoever has the link picks the name and password of their account
+func (app *Application) invitationPage(w http.ResponseWriter, r *http.Request) {
+	invitation, ok := app.invitationFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderInvitationPage(w, r, http.StatusOK, invitation, invitationAcceptForm{})
+}
+
+func (app *Application) invitationAcceptPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	invitation, ok := app.invitationFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form invitationAcceptForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user := &models.User{
+		Name: form.Name,
+		Role: invitation.Role,
+	}
+
+	err = user.Password.Set(form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(form.Password == form.PasswordConfirmation, "password_confirmation", "Passwords do not match")
+	models.ValidateUser(&form.Validator, user)
+
+	if !form.Valid() {
+		app.renderInvitationPage(w, r, http.StatusUnprocessableEntity, invitation, form)
+		return
+	}
+
+	err = app.Models.Invitations.Accept(invitation, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateName):
+			form.AddFieldError("name", "Name is already in use")
+			app.renderInvitationPage(w, r, http.StatusUnprocessableEntity, invitation, form)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.clientError(w, http.StatusNotFound)
+		default:
+			app.serverError(w, r, err)
+		}
+
+		return
+	}
+
+	app.Logger.Info("invitation accepted",
+		"requestId", requestId,
+		"invitationID", invitation.ID,
+		"userId", user.ID,
+	)
+
+	app.auditAs(r, user.ID, models.AuditUserCreate, "user", user.ID, nil, newUserResponse(user))
+
+	app.SessionManager.Put(r.Context(), "flash", "Welcome! Your account was created successfully")
+
+	app.logIn(w, r, user.ID, models.UserThrottleKey(user.Name))
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+)
+
+// Size of the clusters on screen, in pixels
+const mapClusterPixels = 64
+
+type geoJSONGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*geoJSONFeature `json:"features"`
+}
+
+func (app *Application) mapPage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+	app.render(w, r, http.StatusOK, "map.tmpl", tdata)
+}
+
+// GeoJSON of the photos inside ?bbox=west,south,east,north, clustered according to ?zoom
+func (app *Application) mapPhotos(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.Validator{}
+
+	zoom := app.readInt(qs, "zoom", 0, &v)
+	v.CheckField(zoom >= 0 && zoom <= 22, "zoom", "must be between 0 and 22")
+
+	bbox := app.readCSV(qs, "bbox", nil)
+	v.CheckField(len(bbox) == 4, "bbox", "must contain west, south, east and north")
+
+	coords := make([]float64, len(bbox))
+	for i, c := range bbox {
+		var err error
+		coords[i], err = strconv.ParseFloat(c, 64)
+		if err != nil {
+			v.AddFieldError("bbox", "must contain decimal numbers")
+		}
+	}
+
+	if !v.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The map can show the world more than once, so the box can go past the limits
+	box := models.BoundingBox{
+		West:  math.Max(coords[0], -180),
+		South: math.Max(coords[1], -90),
+		East:  math.Min(coords[2], 180),
+		North: math.Min(coords[3], 90),
+	}
+
+	// A 256 pixels tile spans 360 degrees at zoom 0, half of that at each next level
+	cellSize := 360 / math.Pow(2, float64(zoom)) * mapClusterPixels / 256
+
+	clusters, err := app.Models.Photos.GetClusters(box, cellSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Clusters shown by photos of events the user cannot view are hidden
+	visible := []*models.PhotoCluster{}
+	photos := []*models.Photo{}
+	for _, c := range clusters {
+		if hasAccess(access[c.Photo.Event], models.AccessView) {
+			visible = append(visible, c)
+			photos = append(photos, c.Photo)
+		}
+	}
+	clusters = visible
+	app.setThumbNames(photos)
+
+	collection := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []*geoJSONFeature{},
+	}
+
+	for _, c := range clusters {
+		collection.Features = append(collection.Features, &geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{
+				Type: "Point",
+				// GeoJSON wants longitude first
+				Coordinates: [2]float64{c.Longitude, c.Latitude},
+			},
+			Properties: map[string]any{
+				"count": c.Count,
+				"photo": fmt.Sprintf("/photos/view/%s", url.PathEscape(c.Photo.FileName)),
+				"thumb": fmt.Sprintf("/storage/thumbnails/%d/%s", c.Photo.Event, url.PathEscape(c.Photo.ThumbName)),
+			},
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, collection, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,492 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// OpenID Connect provider users can log in with, in addition to their password
+type OIDCProvider struct {
+	issuer        string
+	verifier      *oidc.IDTokenVerifier
+	oauth2        oauth2.Config
+	groupsClaim   string
+	groupRoles    map[string]string // Role given to the members of each group of the provider
+	linkByEmail   bool
+	autoProvision bool
+	defaultRole   string
+}
+
+// Discover the provider configured by cfg.OIDC, which must have an issuer
+func NewOIDCProvider(ctx context.Context, cfg Config) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.OIDC.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(models.Roles, cfg.OIDC.DefaultRole) {
+		return nil, fmt.Errorf("invalid oidc default role %q", cfg.OIDC.DefaultRole)
+	}
+
+	// Groups and roles are given as "group=role,group=role"
+	groupRoles := make(map[string]string)
+	for _, pair := range strings.Split(cfg.OIDC.GroupRoles, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		group, role, found := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !found || group == "" || !slices.Contains(models.Roles, role) {
+			return nil, fmt.Errorf("invalid oidc group role %q", pair)
+		}
+
+		groupRoles[group] = role
+	}
+
+	return &OIDCProvider{
+		issuer:   cfg.OIDC.Issuer,
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.OIDC.ClientID}),
+		oauth2: oauth2.Config{
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       strings.Fields(cfg.OIDC.Scopes),
+		},
+		groupsClaim:   cfg.OIDC.GroupsClaim,
+		groupRoles:    groupRoles,
+		linkByEmail:   cfg.OIDC.LinkByEmail,
+		autoProvision: cfg.OIDC.AutoProvision,
+		defaultRole:   cfg.OIDC.DefaultRole,
+	}, nil
+}
+
+// The highest role given by the groups, empty if none of them is mapped to a role
+func (p *OIDCProvider) roleForGroups(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		r, ok := p.groupRoles[group]
+		if ok && slices.Index(models.Roles, r) > slices.Index(models.Roles, role) {
+			role = r
+		}
+	}
+
+	return role
+}
+
+// Claims of the id token used to find or create the user
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Groups            []string
+}
+
+func (p *OIDCProvider) claims(token *oidc.IDToken) (*oidcClaims, error) {
+	var claims oidcClaims
+
+	err := token.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Providers send the groups either as a list or as a single string
+	var raw map[string]any
+
+	err = token.Claims(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch groups := raw[p.groupsClaim].(type) {
+	case string:
+		claims.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				claims.Groups = append(claims.Groups, s)
+			}
+		}
+	}
+
+	return &claims, nil
+}
+
+func randomString() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// Redirect to the provider, which redirects back to the callback once the user has logged in there.
+// When link is true the account is linked to the authenticated user instead of being used to log in
+func (app *Application) ssoStart(w http.ResponseWriter, r *http.Request, link bool) {
+	if app.OIDC == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	app.SessionManager.Put(r.Context(), "ssoState", state)
+	app.SessionManager.Put(r.Context(), "ssoNonce", nonce)
+	app.SessionManager.Put(r.Context(), "ssoVerifier", verifier)
+	app.SessionManager.Put(r.Context(), "ssoLink", link)
+
+	url := app.OIDC.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (app *Application) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	app.ssoStart(w, r, false)
+}
+
+func (app *Application) accountSSOLinkPost(w http.ResponseWriter, r *http.Request) {
+	app.ssoStart(w, r, true)
+}
+
+// Complete the authorization code flow, returning the verified claims of the user.
+// It redirects to the login page with a flash message if the provider reported an error
+func (app *Application) ssoExchange(w http.ResponseWriter, r *http.Request) (*oidcClaims, bool) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	state := app.SessionManager.PopString(r.Context(), "ssoState")
+	nonce := app.SessionManager.PopString(r.Context(), "ssoNonce")
+	verifier := app.SessionManager.PopString(r.Context(), "ssoVerifier")
+
+	qs := r.URL.Query()
+
+	if state == "" || qs.Get("state") != state {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	if qs.Get("error") != "" {
+		app.Logger.Warn("sso login refused by the provider",
+			"requestId", requestId,
+			"error", qs.Get("error"),
+			"description", qs.Get("error_description"),
+		)
+
+		app.SessionManager.Put(r.Context(), "flash", "Single sign-on failed, try again or log in with your password")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	token, err := app.OIDC.oauth2.Exchange(ctx, qs.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.serverError(w, r, errors.New("missing id token in the oidc token response"))
+		return nil, false
+	}
+
+	idToken, err := app.OIDC.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	if idToken.Nonce != nonce {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	claims, err := app.OIDC.claims(idToken)
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// Find the user the account of the provider belongs to: the one it is linked to, or else the one whose
+// name is its verified email if linking by email is enabled, or else a new user if auto provisioning
+// is enabled. Nil if there is none
+func (app *Application) ssoUser(r *http.Request, claims *oidcClaims) (*models.User, error) {
+	identity, err := app.Models.Identities.Get(app.OIDC.issuer, claims.Subject)
+	if err == nil {
+		return app.Models.Users.GetById(identity.UserID)
+	} else if !errors.Is(err, models.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var user *models.User
+
+	// Off by default, since anyone who gets the email verified by the provider would take over the user
+	if app.OIDC.linkByEmail && claims.EmailVerified && claims.Email != "" {
+		user, err = app.Models.Users.GetByName(claims.Email)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		if !app.OIDC.autoProvision {
+			return nil, nil
+		}
+
+		user, err = app.ssoProvision(r, claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = app.Models.Identities.Insert(&models.Identity{
+		Issuer:  app.OIDC.issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Create a user for the account of the provider. Its password is random, so that it can only log in with the provider
+// until an admin resets it
+func (app *Application) ssoProvision(r *http.Request, claims *oidcClaims) (*models.User, error) {
+	name := claims.PreferredUsername
+	if name == "" {
+		name = claims.Email
+	}
+	if name == "" {
+		name = claims.Subject
+	}
+
+	role := app.OIDC.roleForGroups(claims.Groups)
+	if role == "" {
+		role = app.OIDC.defaultRole
+	}
+
+	password, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Name: name,
+		Role: role,
+	}
+
+	err = user.Password.Set(password[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	app.auditAs(r, user.ID, models.AuditUserCreate, "user", user.ID, nil, newUserResponse(user))
+
+	return user, nil
+}
+
+func (app *Application) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	if app.OIDC == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	link := app.SessionManager.PopBool(r.Context(), "ssoLink")
+
+	claims, ok := app.ssoExchange(w, r)
+	if !ok {
+		return
+	}
+
+	if link {
+		app.ssoLink(w, r, claims)
+		return
+	}
+
+	user, err := app.ssoUser(r, claims)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateName) {
+			app.SessionManager.Put(r.Context(), "flash", "An account with your name already exists, log in with its password and link it from the account page")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user == nil || !user.Active {
+		app.Logger.Warn("sso login without an account",
+			"requestId", requestId,
+			"subject", claims.Subject,
+			"email", claims.Email,
+		)
+
+		app.SessionManager.Put(r.Context(), "flash", "There is no account for you, ask an admin to create one or log in with your password and link it from the account page")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// The role follows the groups, as long as any of them is mapped to one
+	role := app.OIDC.roleForGroups(claims.Groups)
+	if role != "" && role != user.Role {
+		demoted := slices.Index(models.Roles, role) < slices.Index(models.Roles, user.Role)
+		before := newUserResponse(user)
+		user.Role = role
+
+		err = app.Models.Users.Update(user)
+		if err != nil && !errors.Is(err, models.ErrLastAdmin) {
+			app.serverError(w, r, err)
+			return
+		}
+		applied := err == nil
+
+		// Changed by the provider, not by a user
+		if applied {
+			app.auditAs(r, 0, models.AuditUserUpdate, "user", user.ID, before, newUserResponse(user))
+		}
+
+		// Like when an admin demotes the user, the other sessions lose the old role right away
+		if applied && demoted {
+			err = app.destroyUserSessions(r.Context(), user.ID)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		app.Logger.Info("user role changed by sso groups",
+			"requestId", requestId,
+			"userId", user.ID,
+			"role", role,
+			"applied", applied,
+		)
+	}
+
+	app.Logger.Info("sso login",
+		"requestId", requestId,
+		"userId", user.ID,
+		"subject", claims.Subject,
+	)
+
+	app.logInWithTwoFactor(w, r, user.ID, models.UserThrottleKey(user.Name))
+}
+
+// Link the account of the provider to the authenticated user
+func (app *Application) ssoLink(w http.ResponseWriter, r *http.Request, claims *oidcClaims) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	if !app.IsAuthenticated(r) {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := app.Models.Identities.Insert(&models.Identity{
+		Issuer:  app.OIDC.issuer,
+		Subject: claims.Subject,
+		UserID:  app.UserID(r),
+		Email:   claims.Email,
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateName) {
+			app.SessionManager.Put(r.Context(), "flash", "That account is already linked to a user")
+			http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("sso account linked",
+		"requestId", requestId,
+		"userId", app.UserID(r),
+		"subject", claims.Subject,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Single sign-on account linked successfully")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+type ssoUnlinkForm struct {
+	Issuer  string `form:"issuer"`
+	Subject string `form:"subject"`
+}
+
+func (app *Application) accountSSOUnlinkPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form ssoUnlinkForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Identities.Delete(app.UserID(r), form.Issuer, form.Subject)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("sso account unlinked",
+		"requestId", requestId,
+		"userId", app.UserID(r),
+		"subject", form.Subject,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Single sign-on account unlinked successfully")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
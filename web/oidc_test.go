@@ -0,0 +1,37 @@
+package web
+
+import (
+	"sitoWow/internal/data/models"
+	"testing"
+)
+
+func TestRoleForGroups(t *testing.T) {
+	p := &OIDCProvider{groupRoles: map[string]string{
+		"famiglia":   models.RoleViewer,
+		"fotografi":  models.RoleContributor,
+		"redazione":  models.RoleEditor,
+		"amministra": models.RoleAdmin,
+	}}
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   string
+	}{
+		{name: "no groups", groups: nil, want: ""},
+		{name: "unmapped groups", groups: []string{"ospiti", "altri"}, want: ""},
+		{name: "one group", groups: []string{"fotografi"}, want: models.RoleContributor},
+		{name: "unmapped and mapped", groups: []string{"ospiti", "famiglia"}, want: models.RoleViewer},
+		{name: "highest role wins", groups: []string{"redazione", "famiglia", "fotografi"}, want: models.RoleEditor},
+		{name: "admin", groups: []string{"famiglia", "amministra"}, want: models.RoleAdmin},
+		{name: "group names are case sensitive", groups: []string{"Redazione"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.roleForGroups(tt.groups); got != tt.want {
+				t.Errorf("roleForGroups(%v) = %q, want %q", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Whether the access someone has is enough for the one required. Contributors can also view
+func hasAccess(have, want string) bool {
+	switch want {
+	case models.AccessView:
+		return have == models.AccessView || have == models.AccessContribute
+	case models.AccessContribute:
+		return have == models.AccessContribute
+	default:
+		return false
+	}
+}
+
+// Access of the current user on every event, events that cannot be accessed are missing.
+// Users who can access every event can contribute to all of them. For the others, each event
+// uses the permissions of the closest event up its hierarchy that has some: they get the
+// highest access granted to them or their groups there. Events with no permissions up the
+// hierarchy are open, and everyone can contribute to them. Whether they can actually upload
+// or edit is still decided by the permissions of their role
+func (app *Application) eventsAccess(r *http.Request) (map[int]string, error) {
+	if app.Can(r, models.PermissionEventsAccess) {
+		events, err := app.Models.Events.GetAll()
+		if err != nil {
+			return nil, err
+		}
+
+		access := make(map[int]string, len(events))
+		for _, e := range events {
+			access[e.ID] = models.AccessContribute
+		}
+
+		return access, nil
+	}
+
+	return app.Models.Permissions.GetAccess(app.UserID(r), nil)
+}
+
+// Access of the current user on the event, empty if there is none
+func (app *Application) eventAccess(r *http.Request, event int) (string, error) {
+	if app.Can(r, models.PermissionEventsAccess) {
+		return models.AccessContribute, nil
+	}
+
+	access, err := app.Models.Permissions.GetAccess(app.UserID(r), &event)
+	if err != nil {
+		return "", err
+	}
+
+	return access[event], nil
+}
+
+// Check that the current user has the access on the event, responding with not found otherwise,
+// so that the existence of the event is not revealed
+func (app *Application) checkEventAccess(w http.ResponseWriter, r *http.Request, event int, want string) bool {
+	access, err := app.eventAccess(r, event)
+	if err != nil {
+		app.serverError(w, r, err)
+		return false
+	}
+
+	if !hasAccess(access, want) {
+		app.clientError(w, http.StatusNotFound)
+		return false
+	}
+
+	return true
+}
+
+// Keep only the events the current user can view
+func (app *Application) accessibleEvents(r *http.Request, events []*models.Event) ([]*models.Event, error) {
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*models.Event{}
+	for _, e := range events {
+		if hasAccess(access[e.ID], models.AccessView) {
+			res = append(res, e)
+		}
+	}
+
+	return res, nil
+}
+
+// Keep only the photos of the events the current user can view
+func (app *Application) accessiblePhotos(r *http.Request, photos []*models.Photo) ([]*models.Photo, error) {
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*models.Photo{}
+	for _, p := range photos {
+		if hasAccess(access[p.Event], models.AccessView) {
+			res = append(res, p)
+		}
+	}
+
+	return res, nil
+}
+
+// Events the current user can upload photos to
+func (app *Application) contributableEvents(r *http.Request) ([]*models.Event, error) {
+	events, err := app.Models.Events.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*models.Event{}
+	for _, e := range events {
+		if hasAccess(access[e.ID], models.AccessContribute) {
+			res = append(res, e)
+		}
+	}
+
+	return res, nil
+}
+
+// Files in the storage are in photos/<event id>/ and thumbnails/<event id>/,
+// they can only be downloaded by users that can view the event,
+// or by anyone who opened a share link that includes them
+func (app *Application) requireStorageAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The path is cleaned before the event is read from it, otherwise a path like
+		// photos/1/../2/file.jpg would be checked against 1 and served from 2
+		cleaned := path.Clean(r.URL.Path)
+		if !strings.HasPrefix(cleaned, "/storage/") {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		r.URL.Path = cleaned
+		r.URL.RawPath = ""
+
+		parts := strings.SplitN(strings.TrimPrefix(cleaned, "/storage/"), "/", 3)
+		if len(parts) < 3 || (parts[0] != "photos" && parts[0] != "thumbnails") {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		event, err := strconv.Atoi(parts[1])
+		if err != nil {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		shared, err := app.sharedFile(r, parts[2])
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if shared {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !app.IsAuthenticated(r) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		// Like requireTwoFactor, only for the session since tokens do not come from a login
+		if _, ok := r.Context().Value(tokenContextKey).(*models.Token); !ok {
+			missing, err := app.missingTwoFactor(r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if missing {
+				app.SessionManager.Put(r.Context(), "flash", "Administrators must enable two-factor authentication")
+				http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+				return
+			}
+		}
+
+		if !app.checkEventAccess(w, r, event, models.AccessView) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type eventPermissionForm struct {
+	User                int    `form:"user"`
+	Group               int    `form:"group"`
+	Access              string `form:"access"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderEventPermissions(w http.ResponseWriter, r *http.Request, status int, event *models.Event, form eventPermissionForm) {
+	tdata := app.newTemplateData(r)
+
+	var err error
+	tdata.Permissions, err = app.Models.Permissions.GetForEvent(event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Events without permissions take them from their closest ancestor that has some
+	if len(tdata.Permissions) == 0 {
+		ancestors, err := app.Models.Events.GetAncestors(event.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		for i := len(ancestors) - 1; i >= 0; i-- {
+			inherited, err := app.Models.Permissions.GetForEvent(ancestors[i].ID)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if len(inherited) > 0 {
+				tdata.Permissions = inherited
+				tdata.InheritedFrom = ancestors[i]
+				break
+			}
+		}
+	}
+
+	tdata.Users, err = app.Models.Users.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.Groups, err = app.Models.Groups.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.Event = event
+	tdata.Form = form
+	app.render(w, r, status, "eventPermissions.tmpl", tdata)
+}
+
+func (app *Application) eventPermissionsPage(w http.ResponseWriter, r *http.Request) {
+	event, ok := app.eventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	form := eventPermissionForm{Access: models.AccessView}
+	app.renderEventPermissions(w, r, http.StatusOK, event, form)
+}
+
+func (app *Application) eventPermissionsPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	event, ok := app.eventFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form eventPermissionForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	permission := &models.EventPermission{
+		Event:  event.ID,
+		Access: form.Access,
+	}
+	if form.User != 0 {
+		permission.User = &form.User
+	}
+	if form.Group != 0 {
+		permission.Group = &form.Group
+	}
+
+	models.ValidateEventPermission(&form.Validator, permission)
+	if !form.Valid() {
+		app.renderEventPermissions(w, r, http.StatusUnprocessableEntity, event, form)
+		return
+	}
+
+	err = app.Models.Permissions.Set(permission)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			form.AddFieldError("grantee", "User or group not found")
+			app.renderEventPermissions(w, r, http.StatusUnprocessableEntity, event, form)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("event permission set",
+		"requestId", requestId,
+		"eventID", event.ID,
+		"permissionID", permission.ID,
+		"access", permission.Access,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Access granted successfully")
+
+	http.Redirect(w, r, fmt.Sprintf("/events/permissions/%d", event.ID), http.StatusSeeOther)
+}
+
+func (app *Application) eventPermissionDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	var form struct {
+		Event int `form:"event"`
+	}
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Permissions.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("event permission deleted",
+		"requestId", requestId,
+		"permissionID", id,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Access removed successfully")
+
+	http.Redirect(w, r, fmt.Sprintf("/events/permissions/%d", form.Event), http.StatusSeeOther)
+}
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -22,25 +25,139 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-// Not used
+const photoListPageSize = 60
+
+// Url of the photo list page after cursor, with the same filters. Empty if there is none
+func photoListURL(event int, filters url.Values, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+
+	qs := url.Values{}
+	for key, value := range filters {
+		qs[key] = value
+	}
+	qs.Set("event", strconv.Itoa(event))
+	qs.Set("cursor", cursor)
+
+	return fmt.Sprintf("/photos/list?%s", qs.Encode())
+}
+
+// Photo filters as sent in the query string, kept as strings to fill the filters form back
+type photoFiltersForm struct {
+	From                string `form:"from"`
+	To                  string `form:"to"`
+	Type                string `form:"type"`
+	Location            string `form:"location"`
+	Uploader            int    `form:"uploader"`
+	validator.Validator `form:"-"`
+}
+
+// Query string of the filters that are set, to carry them to the next pages
+func (form *photoFiltersForm) query() url.Values {
+	qs := url.Values{}
+
+	set := func(key, value string) {
+		if value != "" {
+			qs.Set(key, value)
+		}
+	}
+
+	set("from", form.From)
+	set("to", form.To)
+	set("type", form.Type)
+	set("location", form.Location)
+	if form.Uploader != 0 {
+		qs.Set("uploader", strconv.Itoa(form.Uploader))
+	}
+
+	return qs
+}
+
+// Read the page size, cursor and photo filters from the query string.
+// Errors are added to the returned form
+func (app *Application) readPhotoFilters(qs url.Values) (photoFiltersForm, data.Filters) {
+	var form photoFiltersForm
+
+	form.From = app.readString(qs, "from", "")
+	form.To = app.readString(qs, "to", "")
+	form.Type = app.readString(qs, "type", "")
+	form.Location = app.readString(qs, "location", "")
+	form.Uploader = app.readInt(qs, "uploader", 0, &form.Validator)
+
+	// Times come from datetime-local inputs. Like taken_at, they are in the camera's time
+	parseTime := func(value, key string) *time.Time {
+		if value == "" {
+			return nil
+		}
+
+		t, err := time.Parse("2006-01-02T15:04", value)
+		if err != nil {
+			form.AddFieldError(key, "must be a valid date and time")
+			return nil
+		}
+
+		return &t
+	}
+
+	filters := data.Filters{
+		PageSize:  app.readInt(qs, "page_size", photoListPageSize, &form.Validator),
+		From:      parseTime(form.From, "from"),
+		To:        parseTime(form.To, "to"),
+		MediaType: form.Type,
+		Location:  form.Location,
+		Uploader:  form.Uploader,
+	}
+
+	cursor, err := data.ParseCursor(app.readString(qs, "cursor", ""))
+	if err != nil {
+		form.AddFieldError("cursor", "invalid cursor")
+	}
+	filters.Cursor = cursor
+
+	// Cursors only work with the date ordering
+	filters.Sort = "taken_at"
+	filters.SortSafelist = []string{"taken_at"}
+
+	data.ValidateFilters(&form.Validator, filters)
+
+	return form, filters
+}
+
+// Set whether the photos are favourites of the current user, and for those who can edit events how many users like them
+func (app *Application) setFavourites(r *http.Request, event int, photos []*models.Photo) error {
+	favourites, counts, err := app.Models.Favourites.GetEventStatus(app.UserID(r), event)
+	if err != nil {
+		return err
+	}
+
+	showCounts := app.Can(r, models.PermissionEventsEdit)
+
+	for i := range photos {
+		photos[i].IsFavourite = favourites[photos[i].ID]
+		// The favourites count helps choosing highlights, so only those who can edit events see it
+		if showCounts {
+			photos[i].Favourites = counts[photos[i].ID]
+		}
+	}
+
+	return nil
+}
+
+// Page of the photos of an event, loaded by htmx while scrolling the event page
 func (app *Application) photoList(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Event int
 		data.Filters
 	}
 
-	v := &validator.Validator{}
-
 	qs := r.URL.Query()
 
-	input.Event = app.readInt(qs, "event", 1, v)
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
-	input.Filters.Sort = app.readString(qs, "sort", "taken_at")
-
-	input.Filters.SortSafelist = []string{"id", "-id", "taken_at", "-taken_at", "latitude", "-latitude", "longitude", "-longitude"}
+	form, filters := app.readPhotoFilters(qs)
+	input.Filters = filters
+	input.Event = app.readInt(qs, "event", 1, &form.Validator)
 
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	if !form.Valid() {
 		data := app.newTemplateData(r)
 		// trovare un modo per mostrare errori
 		// penso che nei casi come questo, in cui viene restituito solo un pezzo di html e non una pagina intera,
@@ -48,7 +165,7 @@ func (app *Application) photoList(w http.ResponseWriter, r *http.Request) {
 		// quindi bisogna restituire html che contiene tutti gli errori, che htmx puo far visualizzare al posto della risposta.
 		// L'html degli errori per essere visualizzato da htmx deve avere codice 200
 		// Forse non dovrei proprio restituire errori
-		data.Validator = v
+		data.Validator = &form.Validator
 		app.renderRaw(w, r, http.StatusOK, "errors.tmpl", data)
 		return
 	}
@@ -64,26 +181,35 @@ func (app *Application) photoList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	access, err := app.eventAccess(r, event.ID)
+	if err != nil {
+		app.serverErrorHTMX(w, r, err)
+		return
+	}
+	if !hasAccess(access, models.AccessView) {
+		app.clientErrorHTMX(w, http.StatusNotFound)
+		return
+	}
+
 	photos, metadata, err := app.Models.Photos.GetFiltered(&input.Event, input.Filters)
 	if err != nil {
 		app.serverErrorHTMX(w, r, err)
 		return
 	}
 
-	// Set thubnail names, replace video extensions with jpg extension (for thumbnail path)
-	for i := range photos {
-		if slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(photos[i].FileName))) {
-			// Thumbnail for video is video filename(with extension)+".jpg"
-			photos[i].ThumbName = fmt.Sprintf("%s%s", path.Base(photos[i].FileName), ".jpg")
-		} else {
-			photos[i].ThumbName = photos[i].FileName
-		}
+	app.setThumbNames(photos)
+
+	err = app.setFavourites(r, event.ID, photos)
+	if err != nil {
+		app.serverErrorHTMX(w, r, err)
+		return
 	}
 
 	tdata := app.newTemplateData(r)
 	tdata.Photos = photos
 	tdata.Event = event
 	tdata.Metadata = &metadata
+	tdata.NextPage = photoListURL(event.ID, form.query(), metadata.NextCursor)
 
 	app.renderRaw(w, r, http.StatusOK, "photoList.tmpl", tdata)
 }
@@ -101,6 +227,60 @@ func (app *Application) photoPage(w http.ResponseWriter, r *http.Request) {
 		}
 
 		app.serverError(w, r, err)
+		return
+	}
+
+	if !app.checkEventAccess(w, r, photo.Event, models.AccessView) {
+		return
+	}
+
+	// When browsing an album, previous and next photos are the ones in the album
+	var album *models.Album
+	if r.URL.Query().Has("album") {
+		v := &validator.Validator{}
+		albumID := app.readInt(r.URL.Query(), "album", 0, v)
+		if !v.Valid() {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		album, err = app.Models.Albums.GetByID(albumID)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				app.clientError(w, http.StatusNotFound)
+				return
+			}
+
+			app.serverError(w, r, err)
+			return
+		}
+
+		photos, err := app.Models.Albums.GetPhotos(album.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// Photos of events the user cannot view are skipped
+		photos, err = app.accessiblePhotos(r, photos)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		i := slices.IndexFunc(photos, func(p *models.Photo) bool { return p.ID == photo.ID })
+		if i == -1 {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		photo.PreviousFile, photo.NextFile = nil, nil
+		if i > 0 {
+			photo.PreviousFile = &photos[i-1].FileName
+		}
+		if i < len(photos)-1 {
+			photo.NextFile = &photos[i+1].FileName
+		}
 	}
 
 	event, err := app.Models.Events.GetByID(photo.Event)
@@ -115,8 +295,28 @@ func (app *Application) photoPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tdata := app.newTemplateData(r)
+
+	photo.IsFavourite, photo.Favourites, err = app.Models.Favourites.Status(app.UserID(r), photo.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only those who can edit events get to see the favourites count
+	if !tdata.Can(models.PermissionEventsEdit) {
+		photo.Favourites = 0
+	}
+
+	comments, err := app.Models.Comments.GetByPhoto(photo.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	tdata.Photo = photo
 	tdata.Event = event
+	tdata.Album = album
+	tdata.Comments = app.commentThreads(r, comments)
 
 	app.render(w, r, http.StatusOK, "photo.tmpl", tdata)
 }
@@ -130,7 +330,7 @@ func (app *Application) photoUploadPage(w http.ResponseWriter, r *http.Request)
 	tdata := app.newTemplateData(r)
 	tdata.Form = photoUploadForm{}
 
-	events, err := app.Models.Events.GetAll()
+	events, err := app.contributableEvents(r)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -145,7 +345,7 @@ func (app *Application) renderPhotosUploadErrors(w http.ResponseWriter, r *http.
 	tdata := app.newTemplateData(r)
 	tdata.Form = form
 
-	events, err := app.Models.Events.GetAll()
+	events, err := app.contributableEvents(r)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -194,43 +394,30 @@ func (app *Application) photoUploadPost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check if photos event directory is allowed (prevent path traversal)
-	photosDir := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(event.ID))
-	if !app.InAllowedPath(photosDir, path.Join(app.Config.StorageDir, "photos")) {
-		form.AddFieldError("event", "Event is not valid")
-		app.renderPhotosUploadErrors(w, r, form)
+	access, err := app.eventAccess(r, event.ID)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
-
-	// Create photos and event directories if not present
-	if _, err := os.Stat(photosDir); errors.Is(err, os.ErrNotExist) {
-		err := os.MkdirAll(photosDir, os.ModePerm)
-		if err != nil {
-			app.serverError(w, r, err)
-			return
-		}
-	}
-
-	// Check if photos event directory is allowed (prevent path traversal)
-	thumbsDir := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(event.ID))
-	if !app.InAllowedPath(thumbsDir, path.Join(app.Config.StorageDir, "thumbnails")) {
-		form.AddFieldError("event", "Event is not valid")
+	if !hasAccess(access, models.AccessContribute) {
+		form.AddFieldError("event", "You cannot upload photos to this event")
 		app.renderPhotosUploadErrors(w, r, form)
 		return
 	}
 
-	// Create thumbnails directory if not present
-	if _, err := os.Stat(thumbsDir); errors.Is(err, os.ErrNotExist) {
-		err := os.MkdirAll(thumbsDir, os.ModePerm)
-		if err != nil {
-			app.serverError(w, r, err)
+	_, _, err = app.eventDirs(event.ID)
+	if err != nil {
+		if errors.Is(err, errInvalidEventDir) {
+			form.AddFieldError("event", "Event is not valid")
+			app.renderPhotosUploadErrors(w, r, form)
 			return
-
 		}
+
+		app.serverError(w, r, err)
+		return
 	}
 
 	// TODO server error message should tell which files have been uploaded
-	// TODO is file name at risk for path traversal?
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
 		form.AddFieldError("files", "You must select at least one file")
@@ -238,158 +425,20 @@ func (app *Application) photoUploadPost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	for _, file := range files {
-		app.Logger.Info("handling photo",
-			"requestId", requestId,
-			"filename", file.Filename,
-			"eventID", event.ID,
-		)
-		var isVideo bool
-
-		if slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(file.Filename))) {
-			isVideo = true
-		}
-
-		if !slices.Contains(models.ImageExtensions, strings.ToLower(path.Ext(file.Filename))) && !isVideo {
-			// This is a non fatal error, add it to the errors and keep going
-			form.AddNonFieldError(fmt.Sprintf("This file is neither a supported image nor video: %s", file.Filename))
-			continue
-		}
-
-		// Save file
-		f, err := file.Open()
-		if err != nil {
-			app.serverError(w, r, err)
-			return
-		}
-
-		newFilePath := path.Join(photosDir, file.Filename)
-
-		destination, err := os.Create(newFilePath)
-		if err != nil {
-			app.serverError(w, r, err)
-			return
-		}
-		defer destination.Close()
-
-		_, err = io.Copy(destination, f)
-		if err != nil {
-			app.serverError(w, r, err)
-			return
-		}
-		destination.Close() // Close file since we need to access it
-
-		// Extract metadata from photo
-		var ExiftoolOut []struct {
-			Latitude  *float32   `json:"GPSLatitude"`
-			Longitude *float32   `json:"GPSLongitude"`
-			TakenAt   *time.Time `json:"DateTimeOriginal"`
-		}
-
-		cmd := exec.Command(
-			"exiftool",
-			"-TAG", "-GPSLatitude#", "-GPSLongitude#", "-DateTimeOriginal", "-TrackCreateDate",
-			"-j",
-			"-d", "%Y-%m-%dT%H:%M:%SZ",
-			newFilePath,
-		)
-
-		stdout, err := cmd.Output()
-		if err != nil {
-			os.Remove(newFilePath)
-			app.serverError(w, r, err)
-			return
-		}
-
-		err = json.Unmarshal(stdout, &ExiftoolOut)
-		if err != nil {
-			os.Remove(newFilePath)
-			form.AddNonFieldError(fmt.Sprintf("This file has invalid latitude, longitude or takenat time: %s", file.Filename))
-
-			app.Logger.Warn("photo ignored",
-				"requestId", requestId,
-				"filename", file.Filename,
-				"eventID", event.ID,
-				"exif-output", stdout,
-				"error", err.Error(),
-			)
-
-			continue
-		}
-
-		// Insert file data in db
-		photo := &models.Photo{
-			FileName:  path.Base(newFilePath),
-			TakenAt:   ExiftoolOut[0].TakenAt,
-			Latitude:  ExiftoolOut[0].Latitude,
-			Longitude: ExiftoolOut[0].Longitude,
-			Event:     event.ID,
-		}
-
-		err = app.Models.Photos.Insert(photo)
+		photo, err := app.storePhoto(requestId, event, file, app.UserID(r))
 		if err != nil {
-			os.Remove(newFilePath)
-			// If there is a non fatal error, add it to the errors and keep going
-			if errors.Is(err, models.ErrDuplicateName) {
-				form.AddNonFieldError(fmt.Sprintf("This file was already uploaded: %s", file.Filename))
-			} else if errors.Is(err, models.ErrInvalidLatLon) {
-				form.AddNonFieldError(fmt.Sprintf("This file has invalid latitude or longitude: %s", file.Filename))
-			} else {
-				app.serverError(w, r, err)
-				return
+			var rejected photoRejectedError
+			if errors.As(err, &rejected) {
+				// This is a non fatal error, add it to the errors and keep going
+				form.AddNonFieldError(string(rejected))
+				continue
 			}
 
-			app.Logger.Warn("photo ignored",
-				"requestId", requestId,
-				"filename", photo.FileName,
-				"eventID", event.ID,
-				"error", err.Error(),
-			)
-
-			continue
-		}
-
-		// Make thumbnail
-		app.Logger.Info("Making thumbnail",
-			"requestId", requestId,
-			"filename", file.Filename,
-			"eventID", event.ID,
-			"isVideo", isVideo,
-		)
-		var magickCmd *exec.Cmd
-		if !isVideo {
-			magickCmd = exec.Command(
-				"mogrify",
-				"-auto-orient",
-				"-path", thumbsDir,
-				"-thumbnail", "500x500",
-				newFilePath,
-			)
-		} else {
-			magickCmd = exec.Command(
-				"magick", "convert",
-				"-resize", "500x500>",
-				fmt.Sprintf("%s[1]", newFilePath),
-				// Thumbnail for video is video filename(with extension)+".jpg"
-				path.Join(thumbsDir, fmt.Sprintf("%s%s", path.Base(newFilePath), ".jpg")),
-			)
-		}
-
-		output, err := magickCmd.CombinedOutput()
-		if err != nil {
-			errorMsg := fmt.Sprintf("Imagemagick error: %s. Output: %s", err.Error(), output)
-			// Rollback
-			app.Models.Photos.Delete(photo.ID)
-			os.Remove(destination.Name())
-			app.serverError(w, r, errors.New(errorMsg))
+			app.serverError(w, r, err)
 			return
 		}
 
-		app.Logger.Info("photo uploaded",
-			"requestId", requestId,
-			"filename", photo.FileName,
-			"photoID", photo.ID,
-			"eventID", event.ID,
-		)
+		app.audit(r, models.AuditPhotoUpload, "photo", photo.ID, nil, photo)
 	}
 
 	// If non fatal errors happened, inform client
@@ -422,6 +471,10 @@ func (app Application) photoDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.checkEventAccess(w, r, input.Event, models.AccessContribute) {
+		return
+	}
+
 	missingFiles := []string{}
 
 	for _, photo := range input.Photos {
@@ -431,21 +484,15 @@ func (app Application) photoDelete(w http.ResponseWriter, r *http.Request) {
 			photo = strings.TrimSuffix(thumbFile, ".jpg")
 		}
 
-		photoPath := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(input.Event), photo)
-		// Prevent path traversal
-		if !app.InAllowedPath(photoPath, path.Join(app.Config.StorageDir, "photos")) {
-			app.clientError(w, http.StatusBadRequest)
-			return
+		// The paths are checked by deletePhoto, using the file name that is stored. Photos
+		// must be in the event whose access has been checked
+		record, err := app.Models.Photos.GetByFile(photo)
+		if err == nil && record.Event != input.Event {
+			err = models.ErrRecordNotFound
 		}
-
-		thumbPath := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(input.Event), thumbFile)
-		// Prevent path traversal
-		if !app.InAllowedPath(thumbPath, path.Join(app.Config.StorageDir, "thumbnails")) {
-			app.clientError(w, http.StatusBadRequest)
-			return
+		if err == nil {
+			err = app.deletePhoto(record, app.UserID(r))
 		}
-
-		err := app.Models.Photos.DeleteByFile(photo)
 		if err != nil {
 			if errors.Is(err, models.ErrRecordNotFound) {
 				missingFiles = append(missingFiles, photo)
@@ -458,19 +505,7 @@ func (app Application) photoDelete(w http.ResponseWriter, r *http.Request) {
 			}
 
 			app.serverError(w, r, err)
-			//return
-		}
-
-		err = os.Remove(thumbPath)
-		if err != nil {
-			app.serverError(w, r, err)
-			//return
-		}
-
-		err = os.Remove(photoPath)
-		if err != nil {
-			app.serverError(w, r, err)
-			//return
+			return
 		}
 
 		app.Logger.Info("photo deleted",
@@ -478,19 +513,62 @@ func (app Application) photoDelete(w http.ResponseWriter, r *http.Request) {
 			"filename", photo,
 			"eventID", input.Event,
 		)
+
+		app.audit(r, models.AuditPhotoDelete, "photo", record.ID, record, nil)
 	}
 
 	if len(missingFiles) > 0 {
 		otherFilesString := ""
 		if len(missingFiles) < len(input.Photos) {
-			otherFilesString = "\nOther files have been deleted."
+			otherFilesString = "\nOther files have been moved to the trash."
 		}
 
 		app.SessionManager.Put(r.Context(), "flash",
 			fmt.Sprintf("These files do not exist: \n\t%s.%s", strings.Join(missingFiles, "\n\t"), otherFilesString))
 	} else {
-		app.SessionManager.Put(r.Context(), "flash", "Files deleted successfully")
+		app.SessionManager.Put(r.Context(), "flash", "Files moved to the trash, an admin can restore them")
+	}
+}
+
+// Move the photo along with its file and thumbnail to the trash
+func (app *Application) deletePhoto(photo *models.Photo, deletedBy int) error {
+	app.setThumbNames([]*models.Photo{photo})
+
+	photosDir := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(photo.Event))
+	// Prevent path traversal
+	if !app.InAllowedPath(path.Join(photosDir, photo.FileName), path.Join(app.Config.StorageDir, "photos")) {
+		return errInvalidEventDir
+	}
+
+	thumbsDir := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(photo.Event))
+	// Prevent path traversal
+	if !app.InAllowedPath(path.Join(thumbsDir, photo.ThumbName), path.Join(app.Config.StorageDir, "thumbnails")) {
+		return errInvalidEventDir
+	}
+
+	// The files are moved before the record is committed, and moved back if it cannot be
+	var itemID int
+	var moved []movedFile
+
+	_, err := app.Models.Trash.TrashPhoto(photo.ID, deletedBy, func(item int) error {
+		itemID = item
+		trashPhotosDir, trashThumbsDir := app.trashDirs(item)
+
+		var err error
+		moved, err = moveFiles(photosDir, trashPhotosDir, []string{photo.FileName})
+		if err != nil {
+			return err
+		}
+
+		thumbs, err := moveFiles(thumbsDir, trashThumbsDir, []string{photo.ThumbName})
+		moved = append(moved, thumbs...)
+		return err
+	})
+	if err != nil {
+		return app.undoTrashMoves(err, itemID, moved)
 	}
+
+	return nil
 }
 
 func (app Application) photoDownload(w http.ResponseWriter, r *http.Request) {
@@ -507,9 +585,13 @@ func (app Application) photoDownload(w http.ResponseWriter, r *http.Request) {
 	}
 	// TODO: check len(photos) > 0
 
-	// Check for files existance
+	if !app.checkEventAccess(w, r, input.Event, models.AccessView) {
+		return
+	}
+
+	// Check for files existance, they must be in the event whose access has been checked
 	for _, photo := range input.Photos {
-		_, err := app.Models.Photos.GetByFile(photo)
+		p, err := app.Models.Photos.GetByFile(photo)
 		if err != nil {
 			if errors.Is(err, models.ErrRecordNotFound) {
 				app.clientError(w, http.StatusNotFound)
@@ -519,6 +601,11 @@ func (app Application) photoDownload(w http.ResponseWriter, r *http.Request) {
 			app.serverError(w, r, err)
 			return
 		}
+
+		if p.Event != input.Event {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
 	}
 
 	if len(input.Photos) == 1 {
@@ -530,7 +617,7 @@ func (app Application) photoDownload(w http.ResponseWriter, r *http.Request) {
 		}
 
 		w.Header().Set("Content-Type", "image")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", input.Photos[0]))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": input.Photos[0]}))
 
 		// There is probably a better way
 		file, err := os.ReadFile(photoPath)
@@ -623,3 +710,217 @@ func (app Application) photoDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+var errInvalidEventDir = errors.New("event directory is not valid")
+
+// Returns the photos and thumbnails directories of an event, creating them if not present
+func (app *Application) eventDirs(event int) (string, string, error) {
+	// Check if photos event directory is allowed (prevent path traversal)
+	photosDir := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(event))
+	if !app.InAllowedPath(photosDir, path.Join(app.Config.StorageDir, "photos")) {
+		return "", "", errInvalidEventDir
+	}
+
+	// Create photos and event directories if not present
+	if _, err := os.Stat(photosDir); errors.Is(err, os.ErrNotExist) {
+		err := os.MkdirAll(photosDir, os.ModePerm)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	// Check if photos event directory is allowed (prevent path traversal)
+	thumbsDir := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(event))
+	if !app.InAllowedPath(thumbsDir, path.Join(app.Config.StorageDir, "thumbnails")) {
+		return "", "", errInvalidEventDir
+	}
+
+	// Create thumbnails directory if not present
+	if _, err := os.Stat(thumbsDir); errors.Is(err, os.ErrNotExist) {
+		err := os.MkdirAll(thumbsDir, os.ModePerm)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return photosDir, thumbsDir, nil
+}
+
+// A file that cannot be stored as a photo, the message can be shown to the user
+type photoRejectedError string
+
+func (e photoRejectedError) Error() string {
+	return string(e)
+}
+
+// Save an uploaded file in the event, along with its thumbnail, and insert it in the db.
+// Files that cannot be stored because of their content return a photoRejectedError.
+// An uploader of 0 means unknown
+func (app *Application) storePhoto(requestId uuid.UUID, event *models.Event, file *multipart.FileHeader, uploader int) (*models.Photo, error) {
+	photosDir, thumbsDir, err := app.eventDirs(event.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.Logger.Info("handling photo",
+		"requestId", requestId,
+		"filename", file.Filename,
+		"eventID", event.ID,
+	)
+	var isVideo bool
+
+	if slices.Contains(models.VideoExtensions, strings.ToLower(path.Ext(file.Filename))) {
+		isVideo = true
+	}
+
+	if !slices.Contains(models.ImageExtensions, strings.ToLower(path.Ext(file.Filename))) && !isVideo {
+		return nil, photoRejectedError(fmt.Sprintf("This file is neither a supported image nor video: %s", file.Filename))
+	}
+
+	// Save file
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The file is written to a temporary path, and only gets its name once it is in the db,
+	// so that a photo with the same name is never overwritten or removed
+	newFilePath := path.Join(photosDir, path.Base(file.Filename))
+
+	destination, err := os.CreateTemp(photosDir, ".upload-*"+path.Ext(newFilePath))
+	if err != nil {
+		return nil, err
+	}
+	tmpFilePath := destination.Name()
+	defer os.Remove(tmpFilePath)
+	defer destination.Close()
+
+	_, err = io.Copy(destination, f)
+	if err != nil {
+		return nil, err
+	}
+	destination.Close() // Close file since we need to access it
+
+	// Extract metadata from photo
+	var ExiftoolOut []struct {
+		Latitude  *float32   `json:"GPSLatitude"`
+		Longitude *float32   `json:"GPSLongitude"`
+		TakenAt   *time.Time `json:"DateTimeOriginal"`
+	}
+
+	cmd := exec.Command(
+		"exiftool",
+		"-TAG", "-GPSLatitude#", "-GPSLongitude#", "-DateTimeOriginal", "-TrackCreateDate",
+		"-j",
+		"-d", "%Y-%m-%dT%H:%M:%SZ",
+		tmpFilePath,
+	)
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(stdout, &ExiftoolOut)
+	if err != nil {
+		app.Logger.Warn("photo ignored",
+			"requestId", requestId,
+			"filename", file.Filename,
+			"eventID", event.ID,
+			"exif-output", stdout,
+			"error", err.Error(),
+		)
+
+		return nil, photoRejectedError(fmt.Sprintf("This file has invalid latitude, longitude or takenat time: %s", file.Filename))
+	}
+
+	// Insert file data in db
+	photo := &models.Photo{
+		FileName:  path.Base(newFilePath),
+		TakenAt:   ExiftoolOut[0].TakenAt,
+		Latitude:  ExiftoolOut[0].Latitude,
+		Longitude: ExiftoolOut[0].Longitude,
+		Event:     event.ID,
+	}
+
+	if uploader != 0 {
+		photo.Uploader = &uploader
+	}
+
+	err = app.Models.Photos.Insert(photo)
+	if err == nil {
+		// os.Link fails if the file exists, even if it is not in the db
+		err = os.Link(tmpFilePath, newFilePath)
+		if err != nil {
+			app.Models.Photos.Delete(photo.ID)
+			if errors.Is(err, os.ErrExist) {
+				err = models.ErrDuplicateName
+			}
+		}
+	}
+	if err != nil {
+		// If there is a non fatal error, the file is rejected
+		var rejected photoRejectedError
+		if errors.Is(err, models.ErrDuplicateName) {
+			rejected = photoRejectedError(fmt.Sprintf("This file was already uploaded: %s", file.Filename))
+		} else if errors.Is(err, models.ErrInvalidLatLon) {
+			rejected = photoRejectedError(fmt.Sprintf("This file has invalid latitude or longitude: %s", file.Filename))
+		} else {
+			return nil, err
+		}
+
+		app.Logger.Warn("photo ignored",
+			"requestId", requestId,
+			"filename", photo.FileName,
+			"eventID", event.ID,
+			"error", err.Error(),
+		)
+
+		return nil, rejected
+	}
+
+	// Make thumbnail
+	app.Logger.Info("Making thumbnail",
+		"requestId", requestId,
+		"filename", file.Filename,
+		"eventID", event.ID,
+		"isVideo", isVideo,
+	)
+	var magickCmd *exec.Cmd
+	if !isVideo {
+		magickCmd = exec.Command(
+			"mogrify",
+			"-auto-orient",
+			"-path", thumbsDir,
+			"-thumbnail", "500x500",
+			newFilePath,
+		)
+	} else {
+		magickCmd = exec.Command(
+			"magick", "convert",
+			"-resize", "500x500>",
+			fmt.Sprintf("%s[1]", newFilePath),
+			// Thumbnail for video is video filename(with extension)+".jpg"
+			path.Join(thumbsDir, fmt.Sprintf("%s%s", path.Base(newFilePath), ".jpg")),
+		)
+	}
+
+	output, err := magickCmd.CombinedOutput()
+	if err != nil {
+		errorMsg := fmt.Sprintf("Imagemagick error: %s. Output: %s", err.Error(), output)
+		// Rollback
+		app.Models.Photos.Delete(photo.ID)
+		os.Remove(newFilePath)
+		return nil, errors.New(errorMsg)
+	}
+
+	app.Logger.Info("photo uploaded",
+		"requestId", requestId,
+		"filename", photo.FileName,
+		"photoID", photo.ID,
+		"eventID", event.ID,
+	)
+
+	return photo, nil
+}
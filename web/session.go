@@ -0,0 +1,112 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data/models"
+
+	"github.com/google/uuid"
+)
+
+type sessionRevokeForm struct {
+	Session string `form:"session"` // All the sessions but the current one are revoked if empty
+	Action  string `form:"-"`       // Url the form is posted to
+}
+
+func (app *Application) renderSessionsPage(w http.ResponseWriter, r *http.Request, user *models.User, action string) {
+	tdata := app.newTemplateData(r)
+	tdata.User = user
+	tdata.Form = sessionRevokeForm{Action: action}
+	tdata.CurrentSession = app.SessionManager.GetString(r.Context(), "sessionID")
+
+	var err error
+	tdata.Sessions, err = app.Models.Sessions.GetForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, http.StatusOK, "sessions.tmpl", tdata)
+}
+
+// Sessions of the authenticated user
+func (app *Application) sessionsPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderSessionsPage(w, r, user, "/user/sessions/revoke")
+}
+
+// Sessions of any user, for admins
+func (app *Application) userSessionsPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderSessionsPage(w, r, user, fmt.Sprintf("/users/sessions/%d", user.ID))
+}
+
+func (app *Application) revokeSessions(w http.ResponseWriter, r *http.Request, user *models.User, redirect string) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form sessionRevokeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if form.Session == "" {
+		err = app.destroyUserSessions(r.Context(), user.ID)
+	} else {
+		err = app.Models.Sessions.Delete(form.Session, user.ID)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("sessions revoked",
+		"requestId", requestId,
+		"userId", user.ID,
+		"session", form.Session,
+		"by", app.UserID(r),
+	)
+
+	if form.Session == "" {
+		app.SessionManager.Put(r.Context(), "flash", "Every other session was logged out")
+	} else {
+		app.SessionManager.Put(r.Context(), "flash", "Session logged out successfully")
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+func (app *Application) sessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.revokeSessions(w, r, user, "/user/sessions")
+}
+
+func (app *Application) userSessionsRevokePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	app.revokeSessions(w, r, user, fmt.Sprintf("/users/sessions/%d", user.ID))
+}
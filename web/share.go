@@ -0,0 +1,453 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Ids of the share links opened in the session, whose password (if any) was entered
+func (app *Application) openShareLinks(r *http.Request) []int {
+	ids, ok := app.SessionManager.Get(r.Context(), "shareLinks").([]int)
+	if !ok {
+		return nil
+	}
+
+	return ids
+}
+
+func (app *Application) openShareLink(r *http.Request, link *models.ShareLink) {
+	ids := app.openShareLinks(r)
+	if !slices.Contains(ids, link.ID) {
+		app.SessionManager.Put(r.Context(), "shareLinks", append(ids, link.ID))
+	}
+}
+
+// Whether a share link opened in the session includes the photo whose original or thumbnail is file
+func (app *Application) sharedFile(r *http.Request, file string) (bool, error) {
+	ids := app.openShareLinks(r)
+	if len(ids) == 0 {
+		return false, nil
+	}
+
+	photo, err := app.Models.Photos.GetByFile(photoFilesFromThumbs([]string{file})[0])
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	for _, id := range ids {
+		// Links that were revoked or have expired are not found
+		link, err := app.Models.ShareLinks.Get(id)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				continue
+			}
+
+			return false, err
+		}
+
+		if link.Includes(photo) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (app *Application) shareLinkFromParams(w http.ResponseWriter, r *http.Request) (*models.ShareLink, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	link, err := app.Models.ShareLinks.GetByToken(params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	// Known since it is in the url, it is needed by the links in the page
+	link.Plaintext = params.ByName("token")
+
+	return link, true
+}
+
+// The photos included in the link
+func (app *Application) sharedPhotos(link *models.ShareLink) ([]*models.Photo, error) {
+	photos, err := app.Models.Photos.GetAll(&link.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*models.Photo{}
+	for _, p := range photos {
+		if link.Includes(p) {
+			res = append(res, p)
+		}
+	}
+
+	app.setThumbNames(res)
+
+	return res, nil
+}
+
+type shareUnlockForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderSharePage(w http.ResponseWriter, r *http.Request, status int, link *models.ShareLink, form shareUnlockForm) {
+	tdata := app.newTemplateData(r)
+	tdata.ShareLink = link
+	tdata.Form = form
+
+	// Photos are only shown once the password has been entered
+	if !link.HasPassword() || slices.Contains(app.openShareLinks(r), link.ID) {
+		var err error
+		tdata.Photos, err = app.sharedPhotos(link)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, status, "share.tmpl", tdata)
+}
+
+// Page of a share link, anyone with the link can see it
+func (app *Application) sharePage(w http.ResponseWriter, r *http.Request) {
+	link, ok := app.shareLinkFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	// The storage middleware lets the files of the links opened in the session through
+	if !link.HasPassword() {
+		app.openShareLink(r, link)
+	}
+
+	app.renderSharePage(w, r, http.StatusOK, link, shareUnlockForm{})
+}
+
+func (app *Application) shareUnlockPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	link, ok := app.shareLinkFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form shareUnlockForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if link.HasPassword() {
+		// Throttled like the logins, so that the password of a leaked link cannot be guessed
+		ip := app.clientIP(r)
+		throttleKeys := []string{models.ShareThrottleKey(link.ID), models.IPThrottleKey(ip)}
+
+		blockedUntil, err := app.Models.Throttles.BlockedUntil(throttleKeys...)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if blockedUntil != nil {
+			wait := max(time.Until(*blockedUntil).Round(time.Second), time.Second)
+
+			app.Logger.Warn("share link unlock blocked",
+				"requestId", requestId,
+				"shareLinkID", link.ID,
+				"ip", ip,
+			)
+
+			form.AddFieldError("password", fmt.Sprintf("Too many wrong passwords, try again in %s", wait))
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+			app.renderSharePage(w, r, http.StatusTooManyRequests, link, form)
+			return
+		}
+
+		matches, err := link.Password.Matches(form.Password)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !matches {
+			failures := 0
+			for _, key := range throttleKeys {
+				throttle, err := app.Models.Throttles.RecordFailure(key)
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+
+				failures = max(failures, throttle.Failures)
+			}
+
+			app.Logger.Warn("wrong share link password",
+				"requestId", requestId,
+				"shareLinkID", link.ID,
+				"ip", ip,
+				"failures", failures,
+			)
+
+			form.AddFieldError("password", "Wrong password")
+			app.renderSharePage(w, r, http.StatusUnprocessableEntity, link, form)
+			return
+		}
+
+		err = app.Models.Throttles.Reset(models.ShareThrottleKey(link.ID))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err = app.SessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.openShareLink(r, link)
+
+	http.Redirect(w, r, "/share/"+link.Plaintext, http.StatusSeeOther)
+}
+
+func (app *Application) shareDownload(w http.ResponseWriter, r *http.Request) {
+	link, ok := app.shareLinkFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if !link.AllowDownload || (link.HasPassword() && !slices.Contains(app.openShareLinks(r), link.ID)) {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	photos, err := app.sharedPhotos(link)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sendPhotosZip(w, r, link.EventName, photos, nil)
+}
+
+type shareCreateForm struct {
+	Event               int      `form:"event"`
+	Photos              []string `form:"photos"`      // Thumbnails of the selected photos, the whole event is shared if empty
+	ExpiryDays          int      `form:"expiry_days"` // 0 means it never expires
+	Password            string   `form:"password"`
+	AllowDownload       bool     `form:"allow_download"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderShareCreatePage(w http.ResponseWriter, r *http.Request, status int, form shareCreateForm) {
+	event, err := app.Models.Events.GetByID(form.Event)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only the events the user can view can be shared
+	if !app.checkEventAccess(w, r, event.ID, models.AccessView) {
+		return
+	}
+
+	tdata := app.newTemplateData(r)
+	tdata.Event = event
+	tdata.Form = form
+	app.render(w, r, status, "shareCreate.tmpl", tdata)
+}
+
+// The event and the selected photos come from the query string, as in /shares/create?event=1&photos=a.jpg
+func (app *Application) shareCreatePage(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	event, err := strconv.Atoi(qs.Get("event"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	form := shareCreateForm{
+		Event:      event,
+		Photos:     qs["photos"],
+		ExpiryDays: 30,
+	}
+
+	app.renderShareCreatePage(w, r, http.StatusOK, form)
+}
+
+func (app *Application) shareCreatePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form shareCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Only the events the user can view can be shared
+	if !app.checkEventAccess(w, r, form.Event, models.AccessView) {
+		return
+	}
+
+	form.CheckField(form.ExpiryDays >= 0 && form.ExpiryDays <= 3650, "expiry_days", "Expiry must be between 0 and 3650 days")
+
+	// Only photos of the shared event can be selected
+	var photos []int
+	for _, file := range photoFilesFromThumbs(form.Photos) {
+		photo, err := app.Models.Photos.GetByFile(file)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if photo == nil || photo.Event != form.Event {
+			form.AddFieldError("photos", "Some of the selected photos are not in the event")
+			break
+		}
+
+		photos = append(photos, photo.ID)
+	}
+
+	var expiry *time.Time
+	if form.ExpiryDays > 0 {
+		e := time.Now().AddDate(0, 0, form.ExpiryDays)
+		expiry = &e
+	}
+
+	link, err := models.GenerateShareLink(form.Event, photos, app.UserID(r), expiry, form.AllowDownload)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if form.Password != "" {
+		err = link.Password.Set(form.Password)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	models.ValidateShareLink(&form.Validator, link)
+
+	if !form.Valid() {
+		app.renderShareCreatePage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.ShareLinks.Insert(link)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("share link created",
+		"requestId", requestId,
+		"shareLinkID", link.ID,
+		"eventID", link.Event,
+	)
+
+	app.SessionManager.Put(r.Context(), "newShareLink", fmt.Sprintf("https://%s/share/%s", r.Host, link.Plaintext))
+	app.SessionManager.Put(r.Context(), "flash", "Share link created successfully, copy it now since it will not be shown again")
+
+	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+}
+
+func (app *Application) sharesPage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+
+	links, err := app.Models.ShareLinks.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Links of the events the user cannot view are not listed
+	access, err := app.eventsAccess(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	for _, link := range links {
+		if hasAccess(access[link.Event], models.AccessView) {
+			tdata.ShareLinks = append(tdata.ShareLinks, link)
+		}
+	}
+
+	// The url is only shown once, right after the link is created
+	tdata.NewShareLink = app.SessionManager.PopString(r.Context(), "newShareLink")
+	app.render(w, r, http.StatusOK, "shares.tmpl", tdata)
+}
+
+func (app *Application) shareDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = app.Models.ShareLinks.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("share link revoked",
+		"requestId", requestId,
+		"shareLinkID", id,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Share link revoked successfully")
+
+	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+}
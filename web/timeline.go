@@ -0,0 +1,181 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sitoWow/internal/data/models"
+	"strconv"
+	"time"
+)
+
+const timelinePageSize = 60
+
+// Photos of the timeline taken in the same day. NewYear and NewMonth are set when the day
+// is the first of its year or month, Continued when the day began in the previous page
+type TimelineDay struct {
+	Day       time.Time
+	NewYear   bool
+	NewMonth  bool
+	Continued bool
+	Photos    []*models.Photo
+}
+
+// Months with photos in the same year, for the timeline scrubber
+type TimelineYear struct {
+	Year   int
+	Months []*models.TimelineMonth
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Group the photos (newest first) by day. prev is the date of the photo shown right before
+// the first one, if any, so that headers are not repeated across pages
+func timelineDays(photos []*models.Photo, prev *time.Time) []*TimelineDay {
+	days := []*TimelineDay{}
+
+	var last *TimelineDay
+	for _, p := range photos {
+		if last == nil || !sameDay(last.Day, *p.TakenAt) {
+			day := &TimelineDay{Day: *p.TakenAt, NewYear: true, NewMonth: true}
+
+			previous := prev
+			if last != nil {
+				previous = &last.Day
+			}
+			if previous != nil {
+				day.NewYear = previous.Year() != day.Day.Year()
+				day.NewMonth = day.NewYear || previous.Month() != day.Day.Month()
+				day.Continued = sameDay(*previous, day.Day)
+			}
+
+			last = day
+			days = append(days, day)
+		}
+
+		last.Photos = append(last.Photos, p)
+	}
+
+	return days
+}
+
+// Group the months by year, they are already ordered
+func timelineYears(months []*models.TimelineMonth) []*TimelineYear {
+	years := []*TimelineYear{}
+
+	for _, m := range months {
+		if len(years) == 0 || years[len(years)-1].Year != m.Month.Year() {
+			years = append(years, &TimelineYear{Year: m.Month.Year()})
+		}
+
+		last := years[len(years)-1]
+		last.Months = append(last.Months, m)
+	}
+
+	return years
+}
+
+// Url of the page following the photos, empty if they were the last ones
+func timelineNextPage(photos []*models.Photo) string {
+	if len(photos) < timelinePageSize {
+		return ""
+	}
+
+	last := photos[len(photos)-1]
+
+	qs := url.Values{}
+	qs.Set("before", last.TakenAt.Format(time.RFC3339))
+	qs.Set("id", strconv.Itoa(last.ID))
+
+	return fmt.Sprintf("/timeline/photos?%s", qs.Encode())
+}
+
+// With ?from=YYYY-MM the timeline starts from the end of that month, otherwise from the newest photo
+func (app *Application) timelinePage(w http.ResponseWriter, r *http.Request) {
+	// Far enough in the future to include every photo
+	before := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if from := r.URL.Query().Get("from"); from != "" {
+		month, err := time.ParseInLocation("2006-01", from, time.Local)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		before = month.AddDate(0, 1, 0)
+	}
+
+	months, err := app.Models.Photos.GetTimelineMonths()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	photos, err := app.Models.Photos.GetTimeline(before, 0, timelinePageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The next page starts after the last photo retrieved, even if it is hidden
+	nextPage := timelineNextPage(photos)
+
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.setThumbNames(photos)
+
+	tdata := app.newTemplateData(r)
+	tdata.Timeline = timelineDays(photos, nil)
+	tdata.TimelineYears = timelineYears(months)
+	tdata.NextPage = nextPage
+
+	app.render(w, r, http.StatusOK, "timeline.tmpl", tdata)
+}
+
+// Next page of the timeline, loaded by htmx while scrolling
+func (app *Application) timelinePhotos(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	before, err := time.Parse(time.RFC3339, qs.Get("before"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(qs.Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	photos, err := app.Models.Photos.GetTimeline(before, id, timelinePageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The next page starts after the last photo retrieved, even if it is hidden
+	nextPage := timelineNextPage(photos)
+
+	photos, err = app.accessiblePhotos(r, photos)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.setThumbNames(photos)
+
+	tdata := app.newTemplateData(r)
+	tdata.Timeline = timelineDays(photos, &before)
+	tdata.NextPage = nextPage
+
+	app.renderRaw(w, r, http.StatusOK, "timelinePhotos.tmpl", tdata)
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+type tokenCreateForm struct {
+	Name                string   `form:"name"`
+	Scopes              []string `form:"scopes"`
+	ExpiryDays          int      `form:"expiry_days"` // 0 means it never expires
+	AllowedScopes       []string `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) allowedScopes(r *http.Request) []string {
+	return models.AllowedScopes(app.UserRole(r))
+}
+
+func (app *Application) renderTokensPage(w http.ResponseWriter, r *http.Request, status int, form tokenCreateForm) {
+	tokens, err := app.Models.Tokens.GetAllForUser(app.UserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.AllowedScopes = app.allowedScopes(r)
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Tokens = tokens
+	// The plaintext is only shown once, right after the token is created
+	data.NewToken = app.SessionManager.PopString(r.Context(), "newToken")
+	app.render(w, r, status, "tokens.tmpl", data)
+}
+
+func (app *Application) tokensPage(w http.ResponseWriter, r *http.Request) {
+	form := tokenCreateForm{
+		Scopes:     []string{models.ScopeRead},
+		ExpiryDays: 90,
+	}
+
+	app.renderTokensPage(w, r, http.StatusOK, form)
+}
+
+func (app *Application) tokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form tokenCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(form.ExpiryDays >= 0 && form.ExpiryDays <= 3650, "expiry_days", "Expiry must be between 0 and 3650 days")
+	for _, s := range form.Scopes {
+		form.CheckField(validator.PermittedValue(s, app.allowedScopes(r)...), "scopes", "You cannot create tokens with this scope")
+	}
+
+	var expiry *time.Time
+	if form.ExpiryDays > 0 {
+		e := time.Now().AddDate(0, 0, form.ExpiryDays)
+		expiry = &e
+	}
+
+	token, err := models.GenerateToken(app.UserID(r), form.Name, form.Scopes, expiry)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	models.ValidateToken(&form.Validator, token)
+
+	if !form.Valid() {
+		app.renderTokensPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.Tokens.Insert(token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("token created",
+		"requestId", requestId,
+		"tokenID", token.ID,
+		"userID", token.UserID,
+	)
+
+	app.SessionManager.Put(r.Context(), "newToken", token.Plaintext)
+	app.SessionManager.Put(r.Context(), "flash", "Token created successfully, copy it now since it will not be shown again")
+
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+func (app *Application) tokenDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	// Users can only revoke their own tokens
+	err = app.Models.Tokens.DeleteForUser(id, app.UserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("token revoked",
+		"requestId", requestId,
+		"tokenID", id,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Token revoked successfully")
+
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,336 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sitoWow/internal/data/models"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// How often items older than the retention period are purged
+const trashPurgeInterval = time.Hour
+
+// Returns the photos and thumbnails directories of an item in the trash. The trash is not
+// in photos/ or thumbnails/, so the storage server does not serve it
+func (app *Application) trashDirs(item int) (string, string) {
+	dir := path.Join(app.Config.StorageDir, "trash", strconv.Itoa(item))
+
+	return path.Join(dir, "photos"), path.Join(dir, "thumbnails")
+}
+
+// Move a file without replacing the destination, os.Link fails if it exists already
+func moveFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// Move the files from a directory to another, creating it if not present. If names is nil all the
+// files are moved. Missing files are skipped. The files moved are returned even if it fails
+// halfway, so that they can be moved back
+func moveFiles(src, dst string, names []string) ([]movedFile, error) {
+	if names == nil {
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+	}
+
+	err := os.MkdirAll(dst, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []movedFile
+
+	for _, name := range names {
+		file := movedFile{src: path.Join(src, name), dst: path.Join(dst, name)}
+
+		err := moveFile(file.src, file.dst)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return moved, err
+		}
+
+		moved = append(moved, file)
+	}
+
+	return moved, nil
+}
+
+// Move back the files moved to the trash when their records could not be trashed. The files that
+// cannot be moved back are left in the trash while their records are not, so it is logged
+func (app *Application) undoTrashMoves(err error, item int, moved []movedFile) error {
+	undoErr := undoMoves(moved)
+	if undoErr != nil {
+		app.Logger.Error("files left in the trash without their records",
+			"trashItem", item,
+			"error", undoErr.Error(),
+		)
+
+		return errors.Join(err, undoErr)
+	}
+
+	if item != 0 {
+		os.RemoveAll(path.Join(app.Config.StorageDir, "trash", strconv.Itoa(item)))
+	}
+
+	return err
+}
+
+type movedFile struct {
+	src string
+	dst string
+}
+
+// Move back the files, in case the records they belong to could not be restored
+func undoMoves(moved []movedFile) error {
+	var errs []error
+
+	for _, file := range moved {
+		errs = append(errs, moveFile(file.dst, file.src))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Move all the files of the directories in the trash to the ones in the storage. Returns
+// ErrDuplicateName if a file exists already, after moving back the ones moved until then
+func restoreFiles(dirs [][2]string) ([]movedFile, error) {
+	var moved []movedFile
+
+	for _, dir := range dirs {
+		src, dst := dir[0], dir[1]
+
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, errors.Join(err, undoMoves(moved))
+		}
+
+		err = os.MkdirAll(dst, os.ModePerm)
+		if err != nil {
+			return nil, errors.Join(err, undoMoves(moved))
+		}
+
+		for _, entry := range entries {
+			file := movedFile{src: path.Join(src, entry.Name()), dst: path.Join(dst, entry.Name())}
+
+			err := moveFile(file.src, file.dst)
+			if err != nil {
+				if errors.Is(err, os.ErrExist) {
+					err = models.ErrDuplicateName
+				}
+
+				return nil, errors.Join(err, undoMoves(moved))
+			}
+
+			moved = append(moved, file)
+		}
+	}
+
+	return moved, nil
+}
+
+func (app *Application) trashPage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+	tdata.TrashRetentionDays = int(app.Config.TrashRetention.Hours() / 24)
+
+	var err error
+	tdata.TrashItems, err = app.Models.Trash.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, http.StatusOK, "trash.tmpl", tdata)
+}
+
+func (app *Application) trashItemFromParams(w http.ResponseWriter, r *http.Request) (*models.TrashItem, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	item, err := app.Models.Trash.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return item, true
+}
+
+// Restore the records of the item and move its files back to its event. The records are inserted
+// first, so that a photo with the same name is found before any file is moved, and they are only
+// committed once all the files are in place. No existing file is ever replaced
+func (app *Application) restoreTrashItem(item *models.TrashItem) error {
+	photosDir := path.Join(app.Config.StorageDir, "photos", strconv.Itoa(item.Event))
+	thumbsDir := path.Join(app.Config.StorageDir, "thumbnails", strconv.Itoa(item.Event))
+	trashPhotosDir, trashThumbsDir := app.trashDirs(item.ID)
+
+	var moved []movedFile
+
+	err := app.Models.Trash.Restore(item.ID, func() error {
+		var err error
+		moved, err = restoreFiles([][2]string{{trashPhotosDir, photosDir}, {trashThumbsDir, thumbsDir}})
+		return err
+	})
+	if err != nil {
+		// The files were moved but the records could not be committed, so they go back to the trash
+		return errors.Join(err, undoMoves(moved))
+	}
+
+	return os.RemoveAll(path.Join(app.Config.StorageDir, "trash", strconv.Itoa(item.ID)))
+}
+
+func (app *Application) trashRestorePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	item, ok := app.trashItemFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.restoreTrashItem(item)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTrashEventMissing):
+			app.SessionManager.Put(r.Context(), "flash", "The event of the photo was deleted, restore it first")
+			http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		case errors.Is(err, models.ErrDuplicateName):
+			app.SessionManager.Put(r.Context(), "flash", "A photo with the same file name was uploaded in the meantime")
+			http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.clientError(w, http.StatusNotFound)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.Logger.Info("trash item restored",
+		"requestId", requestId,
+		"trashId", item.ID,
+		"kind", item.Kind,
+		"eventID", item.Event,
+	)
+
+	if item.Kind == models.TrashKindEvent {
+		app.audit(r, models.AuditEventRestore, "event", item.Event, nil, item)
+	} else {
+		app.audit(r, models.AuditPhotoRestore, "photo", item.Name, nil, item)
+	}
+
+	app.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s restored successfully", item.Name))
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// Delete the item and its files for good
+func (app *Application) purgeTrashItem(item *models.TrashItem) error {
+	err := app.Models.Trash.Delete(item.ID)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(path.Join(app.Config.StorageDir, "trash", strconv.Itoa(item.ID)))
+}
+
+func (app *Application) trashPurgePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	item, ok := app.trashItemFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.purgeTrashItem(item)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("trash item purged",
+		"requestId", requestId,
+		"trashId", item.ID,
+		"kind", item.Kind,
+	)
+
+	app.audit(r, models.AuditTrashPurge, item.Kind, item.Name, item, nil)
+
+	app.SessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s deleted for good", item.Name))
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// Purge the items older than the retention period, every trashPurgeInterval. Runs until the process exits
+func (app *Application) purgeTrash() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		items, err := app.Models.Trash.GetExpired(time.Now().Add(-app.Config.TrashRetention))
+		if err != nil {
+			app.Logger.Error("trash purge failed", "error", err.Error())
+		}
+
+		for _, item := range items {
+			err := app.purgeTrashItem(item)
+			if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+				app.Logger.Error("trash purge failed",
+					"trashId", item.ID,
+					"error", err.Error(),
+				)
+				continue
+			}
+
+			app.Logger.Info("trash item purged",
+				"trashId", item.ID,
+				"kind", item.Kind,
+				"deletedAt", item.DeletedAt,
+			)
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,499 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/http"
+	"regexp"
+	"sitoWow/internal/data/models"
+	"sitoWow/internal/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+)
+
+// Time the user has to enter the code after the password was accepted
+const twoFactorLoginTimeout = 5 * time.Minute
+
+var totpCodeRX = regexp.MustCompile(`^\d{6}$`)
+
+// Whether the current user is an admin who has not enabled the two factor, while the setting requires it
+func (app *Application) missingTwoFactor(r *http.Request) (bool, error) {
+	if app.UserRole(r) != models.RoleAdmin {
+		return false, nil
+	}
+
+	required, err := app.Models.Settings.GetBool(models.SettingRequireAdminTwoFactor)
+	if err != nil || !required {
+		return false, err
+	}
+
+	_, err = app.Models.TwoFactor.Get(app.UserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return false, nil
+}
+
+// Users whose role is admin must enable the two factor before doing anything else, when the setting requires it.
+// It must come after requireAuthentication
+func (app *Application) requireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		missing, err := app.missingTwoFactor(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if missing {
+			app.SessionManager.Put(r.Context(), "flash", "Administrators must enable two-factor authentication")
+			http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Like requireTwoFactor, but for the API. Only requests authenticated by the session are checked,
+// since tokens do not come from a login
+func (app *Application) apiRequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(tokenContextKey).(*models.Token); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		missing, err := app.missingTwoFactor(r)
+		if err != nil {
+			app.apiServerError(w, r, err)
+			return
+		}
+
+		if missing {
+			app.apiTwoFactorRequired(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// QR code of the otpauth:// url, as a data uri that can be used as the src of an img
+func totpQRCode(url string) (template.URL, error) {
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return "", err
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+type loginTwoFactorForm struct {
+	Code                string `form:"code"` // TOTP code or recovery code
+	validator.Validator `form:"-"`
+}
+
+// The user whose password was accepted by the first login step, false if there is none or it took too long
+func (app *Application) pendingTwoFactorUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id := app.SessionManager.GetInt(r.Context(), "twoFactorUserID")
+	expiry := app.SessionManager.GetInt64(r.Context(), "twoFactorExpiry")
+
+	if id == 0 || time.Now().Unix() > expiry {
+		app.SessionManager.Remove(r.Context(), "twoFactorUserID")
+		app.SessionManager.Remove(r.Context(), "twoFactorExpiry")
+
+		app.SessionManager.Put(r.Context(), "flash", "Login expired, please log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return nil, false
+	}
+
+	user, err := app.Models.Users.GetById(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *Application) loginTwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	_, ok := app.pendingTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = loginTwoFactorForm{}
+	app.render(w, r, http.StatusOK, "loginTwoFactor.tmpl", data)
+}
+
+func (app *Application) loginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.pendingTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+
+	var form loginTwoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	ip := app.clientIP(r)
+	throttleKeys := []string{models.UserThrottleKey(user.Name), models.IPThrottleKey(ip)}
+
+	blockedUntil, err := app.Models.Throttles.BlockedUntil(throttleKeys...)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if blockedUntil != nil {
+		wait := max(time.Until(*blockedUntil).Round(time.Second), time.Second)
+
+		app.Logger.Warn("login blocked",
+			"requestId", requestId,
+			"name", user.Name,
+			"ip", ip,
+		)
+
+		form.AddNonFieldError(fmt.Sprintf("Too many failed logins, try again in %s", wait))
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "loginTwoFactor.tmpl", data)
+		return
+	}
+
+	twoFactor, err := app.Models.TwoFactor.Get(user.ID)
+	if err != nil {
+		// Disabled in the meantime, the password alone is enough now
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.logIn(w, r, user.ID, models.UserThrottleKey(user.Name))
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	var valid bool
+	if totpCodeRX.MatchString(form.Code) {
+		step, matches := twoFactor.Check(form.Code, time.Now())
+		if matches {
+			// A code can only be used once, even within its period
+			valid, err = app.Models.TwoFactor.UseStep(user.ID, step)
+		}
+	} else {
+		valid, err = app.Models.TwoFactor.UseRecoveryCode(user.ID, form.Code)
+		if valid {
+			app.Logger.Info("recovery code used",
+				"requestId", requestId,
+				"userId", user.ID,
+			)
+		}
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !valid {
+		failures := 0
+		for _, key := range throttleKeys {
+			throttle, err := app.Models.Throttles.RecordFailure(key)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			failures = max(failures, throttle.Failures)
+		}
+
+		app.Logger.Warn("login two factor failed",
+			"requestId", requestId,
+			"name", user.Name,
+			"ip", ip,
+			"failures", failures,
+		)
+
+		app.auditAs(r, 0, models.AuditLoginFailed, "name", user.Name, nil, nil)
+
+		form.Code = ""
+		form.AddFieldError("code", "Code is incorrect")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "loginTwoFactor.tmpl", data)
+		return
+	}
+
+	app.SessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.SessionManager.Remove(r.Context(), "twoFactorExpiry")
+
+	app.logIn(w, r, user.ID, models.UserThrottleKey(user.Name))
+}
+
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderTwoFactorPage(w http.ResponseWriter, r *http.Request, status int, form twoFactorForm) {
+	tdata := app.newTemplateData(r)
+	tdata.Form = form
+
+	_, err := app.Models.TwoFactor.Get(app.UserID(r))
+	if err == nil {
+		tdata.TwoFactorEnabled = true
+
+		// Recovery codes are only shown once, right after the two factor is enabled
+		tdata.RecoveryCodes, _ = app.SessionManager.Pop(r.Context(), "recoveryCodes").([]string)
+
+		app.render(w, r, status, "twoFactor.tmpl", tdata)
+		return
+	} else if !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The secret is kept in the session until the user proves to have added it to their app
+	url := app.SessionManager.GetString(r.Context(), "twoFactorURL")
+	if url == "" {
+		user, ok := app.currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		twoFactor, err := models.GenerateTwoFactor(user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		url = twoFactor.URL
+		app.SessionManager.Put(r.Context(), "twoFactorURL", url)
+	}
+
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.TOTPSecret = key.Secret()
+	tdata.TOTPQRCode, err = totpQRCode(url)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, status, "twoFactor.tmpl", tdata)
+}
+
+func (app *Application) twoFactorPage(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactorPage(w, r, http.StatusOK, twoFactorForm{})
+}
+
+func (app *Application) twoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	url := app.SessionManager.GetString(r.Context(), "twoFactorURL")
+	if url == "" {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	twoFactor := &models.TwoFactor{
+		UserID: app.UserID(r),
+		Secret: key.Secret(),
+		URL:    url,
+	}
+
+	step, matches := twoFactor.Check(form.Code, time.Now())
+	form.CheckField(matches, "code", "Code is incorrect, check the time of your device")
+
+	if !form.Valid() {
+		form.Code = ""
+		app.renderTwoFactorPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = twoFactor.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.Models.TwoFactor.Enable(twoFactor)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The code just entered cannot be used to log in
+	_, err = app.Models.TwoFactor.UseStep(twoFactor.UserID, step)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("two factor enabled",
+		"requestId", requestId,
+		"userId", twoFactor.UserID,
+	)
+
+	app.SessionManager.Remove(r.Context(), "twoFactorURL")
+	app.SessionManager.Put(r.Context(), "recoveryCodes", twoFactor.RecoveryCodes)
+	app.SessionManager.Put(r.Context(), "flash", "Two-factor authentication enabled, save the recovery codes now since they will not be shown again")
+
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+func (app *Application) twoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	matches, err := user.Password.Matches(form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(matches, "password", "Password is incorrect")
+
+	if user.Role == models.RoleAdmin {
+		required, err := app.Models.Settings.GetBool(models.SettingRequireAdminTwoFactor)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if required {
+			form.AddNonFieldError("Two-factor authentication is required for administrators")
+		}
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactorPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.TwoFactor.Disable(user.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("two factor disabled",
+		"requestId", requestId,
+		"userId", user.ID,
+	)
+
+	app.SessionManager.Put(r.Context(), "flash", "Two-factor authentication disabled")
+
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+type twoFactorSettingForm struct {
+	Required            bool `form:"required"`
+	validator.Validator `form:"-"`
+}
+
+// Whether administrators must enable the two factor
+func (app *Application) twoFactorSettingPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	var form twoFactorSettingForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Settings.SetBool(models.SettingRequireAdminTwoFactor, form.Required)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("admin two factor requirement changed",
+		"requestId", requestId,
+		"required", form.Required,
+	)
+
+	if form.Required {
+		app.SessionManager.Put(r.Context(), "flash", "Two-factor authentication is now required for administrators")
+	} else {
+		app.SessionManager.Put(r.Context(), "flash", "Two-factor authentication is now optional for administrators")
+	}
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
@@ -2,23 +2,28 @@ package web
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"sitoWow/internal/data/models"
 	"sitoWow/internal/validator"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
 )
 
 type userCreateForm struct {
 	Name                string `form:"name"`
 	Password            string `form:"password"`
-	Level               int    `form:"level"`
+	Role                string `form:"role"`
 	validator.Validator `form:"-"`
 }
 
 func (app *Application) userCreatePage(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = userCreateForm{}
+	data.Form = userCreateForm{Role: models.RoleViewer}
 	app.render(w, r, http.StatusOK, "userCreate.tmpl", data)
 }
 
@@ -32,8 +37,8 @@ func (app *Application) userCreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user := &models.User{
-		Name:  form.Name,
-		Level: form.Level,
+		Name: form.Name,
+		Role: form.Role,
 	}
 
 	err = user.Password.Set(form.Password)
@@ -66,6 +71,8 @@ func (app *Application) userCreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.audit(r, models.AuditUserCreate, "user", user.ID, nil, newUserResponse(user))
+
 	err = app.SessionManager.RenewToken(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
@@ -111,14 +118,61 @@ func (app *Application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := app.clientIP(r)
+	throttleKeys := []string{models.UserThrottleKey(form.Name), models.IPThrottleKey(ip)}
+
+	// Checked before the password, so that blocked attempts do not cost a bcrypt comparison
+	blockedUntil, err := app.Models.Throttles.BlockedUntil(throttleKeys...)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if blockedUntil != nil {
+		wait := max(time.Until(*blockedUntil).Round(time.Second), time.Second)
+
+		app.Logger.Warn("login blocked",
+			"requestId", requestId,
+			"name", form.Name,
+			"ip", ip,
+		)
+
+		form.AddNonFieldError(fmt.Sprintf("Too many failed logins, try again in %s", wait))
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.tmpl", data)
+		return
+	}
+
 	id, err := app.Models.Users.Authenticate(form.Name, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			// Both the username and the ip address are throttled, so that an attacker
+			// can neither try many passwords for a user nor a few for many users
+			failures := 0
+			for _, key := range throttleKeys {
+				throttle, err := app.Models.Throttles.RecordFailure(key)
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+
+				failures = max(failures, throttle.Failures)
+			}
+
 			app.Logger.Warn("login failed",
 				"requestId", requestId,
 				"name", form.Name,
+				"ip", ip,
+				"failures", failures,
 			)
 
+			// The name may not belong to any user
+			app.auditAs(r, 0, models.AuditLoginFailed, "name", form.Name, nil, nil)
+
 			form.AddNonFieldError("Name or password is incorrect")
 
 			data := app.newTemplateData(r)
@@ -130,6 +184,54 @@ func (app *Application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.logInWithTwoFactor(w, r, id, models.UserThrottleKey(form.Name))
+}
+
+// Log in the user, or ask for the second factor first if they enabled it
+func (app *Application) logInWithTwoFactor(w http.ResponseWriter, r *http.Request, id int, throttleKey string) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	_, err := app.Models.TwoFactor.Get(id)
+	if err == nil {
+		// The session is only authenticated once the second step is completed too, the
+		// throttles are kept so that failed codes keep counting towards the same limit
+		err = app.SessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.SessionManager.Put(r.Context(), "twoFactorUserID", id)
+		app.SessionManager.Put(r.Context(), "twoFactorExpiry", time.Now().Add(twoFactorLoginTimeout).Unix())
+
+		app.Logger.Info("login password accepted, two factor required",
+			"requestId", requestId,
+			"userId", id,
+		)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	} else if !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.logIn(w, r, id, throttleKey)
+}
+
+// Authenticate the session as the user, once all the login steps have been completed. Only the
+// throttle of the user is reset: resetting the one of the IP would let anyone with an account
+// clear it by logging in between guesses
+func (app *Application) logIn(w http.ResponseWriter, r *http.Request, id int, throttleKey string) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	err := app.Models.Throttles.Reset(throttleKey)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	err = app.SessionManager.RenewToken(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
@@ -138,10 +240,18 @@ func (app *Application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	app.SessionManager.Put(r.Context(), "authenticatedUserID", id)
 
+	err = app.registerSession(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	app.Logger.Info("login successful",
 		"requestId", requestId,
 		"userId", id,
 	)
+
+	app.auditAs(r, id, models.AuditLogin, "user", id, nil, nil)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -155,8 +265,17 @@ func (app *Application) userLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = app.Models.Sessions.Delete(app.SessionManager.GetString(r.Context(), "sessionID"), app.UserID(r))
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, models.AuditLogout, "user", app.UserID(r), nil, nil)
+
 	id := app.SessionManager.Get(r.Context(), "authenticatedUserID")
 	app.SessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.SessionManager.Remove(r.Context(), "sessionID")
 
 	app.SessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
 
@@ -166,3 +285,462 @@ func (app *Application) userLogout(w http.ResponseWriter, r *http.Request) {
 	)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+func (app *Application) userFromParams(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+
+	user, err := app.Models.Users.GetById(id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, http.StatusNotFound)
+			return nil, false
+		}
+
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *Application) usersPage(w http.ResponseWriter, r *http.Request) {
+	tdata := app.newTemplateData(r)
+
+	var err error
+	tdata.Users, err = app.Models.Users.GetAll()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.LoginThrottles, err = app.Models.Throttles.GetForUsers()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.TwoFactorUsers, err = app.Models.TwoFactor.GetEnabledUsers()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tdata.RequireAdminTwoFactor, err = app.Models.Settings.GetBool(models.SettingRequireAdminTwoFactor)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, http.StatusOK, "users.tmpl", tdata)
+}
+
+// Forget the failed logins of the user, so that they can log in again right away
+func (app *Application) userUnlockPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.Throttles.Reset(models.UserThrottleKey(user.Name))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("user unlocked",
+		"requestId", requestId,
+		"userId", user.ID,
+	)
+
+	app.audit(r, models.AuditUserUnlock, "user", user.ID, nil, nil)
+
+	app.SessionManager.Put(r.Context(), "flash", "User unlocked successfully")
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+// Used both to update the role of a user and to reset their password
+type userUpdateForm struct {
+	Role                string `form:"role"`
+	Active              bool   `form:"active"`
+	Password            string `form:"password"`
+	Version             int    `form:"version"`
+	validator.Validator `form:"-"`
+}
+
+func (app *Application) renderUserUpdatePage(w http.ResponseWriter, r *http.Request, status int, user *models.User, form userUpdateForm) {
+	tdata := app.newTemplateData(r)
+	tdata.User = user
+	tdata.Form = form
+	app.render(w, r, status, "userUpdate.tmpl", tdata)
+}
+
+func (app *Application) userUpdatePage(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	form := userUpdateForm{
+		Role:    user.Role,
+		Active:  user.Active,
+		Version: user.Version,
+	}
+
+	app.renderUserUpdatePage(w, r, http.StatusOK, user, form)
+}
+
+func (app *Application) userUpdatePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form userUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Losing permissions takes effect right away, instead of when the sessions expire
+	demoted := slices.Index(models.Roles, form.Role) < slices.Index(models.Roles, user.Role) || (user.Active && !form.Active)
+	before := newUserResponse(user)
+
+	user.Role = form.Role
+	user.Active = form.Active
+	user.Version = form.Version
+
+	models.ValidateUser(&form.Validator, user)
+	if !form.Valid() {
+		app.renderUserUpdatePage(w, r, http.StatusUnprocessableEntity, user, form)
+		return
+	}
+
+	err = app.Models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrLastAdmin):
+			form.AddFieldError("role", "There must always be at least one active admin")
+			app.renderUserUpdatePage(w, r, http.StatusUnprocessableEntity, user, form)
+		case errors.Is(err, models.ErrEditConflict):
+			app.clientError(w, http.StatusConflict)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if demoted {
+		err = app.destroyUserSessions(r.Context(), user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.Logger.Info("user updated",
+		"requestId", requestId,
+		"userId", user.ID,
+		"role", user.Role,
+		"active", user.Active,
+		"sessionsRevoked", demoted,
+	)
+
+	app.audit(r, models.AuditUserUpdate, "user", user.ID, before, newUserResponse(user))
+
+	app.SessionManager.Put(r.Context(), "flash", "User updated successfully")
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+func (app *Application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var form userUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = user.Password.Set(form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	user.Version = form.Version
+
+	models.ValidateUser(&form.Validator, user)
+	if !form.Valid() {
+		// The other fields of the page show the current values
+		form.Role = user.Role
+		form.Active = user.Active
+		app.renderUserUpdatePage(w, r, http.StatusUnprocessableEntity, user, form)
+		return
+	}
+
+	err = app.Models.Users.Update(user)
+	if err != nil {
+		if errors.Is(err, models.ErrEditConflict) {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("user password reset",
+		"requestId", requestId,
+		"userId", user.ID,
+	)
+
+	app.audit(r, models.AuditUserPassword, "user", user.ID, nil, nil)
+
+	app.SessionManager.Put(r.Context(), "flash", "Password reset successfully")
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+// Deleting a user also deletes their comments and favourites, deactivating them keeps everything
+func (app *Application) userDeletePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	// Kept for the audit log
+	user, ok := app.userFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.Users.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrLastAdmin):
+			app.SessionManager.Put(r.Context(), "flash", "The last active admin cannot be deleted")
+			http.Redirect(w, r, "/users", http.StatusSeeOther)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.clientError(w, http.StatusNotFound)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.Logger.Info("user deleted",
+		"requestId", requestId,
+		"userId", user.ID,
+	)
+
+	app.audit(r, models.AuditUserDelete, "user", user.ID, newUserResponse(user), nil)
+
+	app.SessionManager.Put(r.Context(), "flash", "User deleted successfully")
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+// Used both to change the name and the password of the current user
+type userAccountForm struct {
+	Name                    string `form:"name"`
+	CurrentPassword         string `form:"current_password"`
+	NewPassword             string `form:"new_password"`
+	NewPasswordConfirmation string `form:"new_password_confirmation"`
+	Version                 int    `form:"version"`
+	validator.Validator     `form:"-"`
+}
+
+func (app *Application) renderAccountPage(w http.ResponseWriter, r *http.Request, status int, form userAccountForm) {
+	tdata := app.newTemplateData(r)
+	tdata.Form = form
+
+	var err error
+	tdata.Identities, err = app.Models.Identities.GetForUser(app.UserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, status, "account.tmpl", tdata)
+}
+
+func (app *Application) currentUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := app.Models.Users.GetById(app.UserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *Application) accountPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	form := userAccountForm{
+		Name:    user.Name,
+		Version: user.Version,
+	}
+
+	app.renderAccountPage(w, r, http.StatusOK, form)
+}
+
+func (app *Application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var form userAccountForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	before := newUserResponse(user)
+	user.Name = form.Name
+	user.Version = form.Version
+
+	models.ValidateUser(&form.Validator, user)
+	if !form.Valid() {
+		app.renderAccountPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateName):
+			form.AddFieldError("name", "Name is already in use")
+			app.renderAccountPage(w, r, http.StatusUnprocessableEntity, form)
+		case errors.Is(err, models.ErrEditConflict):
+			app.clientError(w, http.StatusConflict)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.Logger.Info("user name changed",
+		"requestId", requestId,
+		"userId", user.ID,
+	)
+
+	app.audit(r, models.AuditUserUpdate, "user", user.ID, before, newUserResponse(user))
+
+	app.SessionManager.Put(r.Context(), "flash", "Name changed successfully")
+
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *Application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	// This panics if the request id is not present in the context
+	requestId := r.Context().Value(requestIdContextKey).(uuid.UUID)
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var form userAccountForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The name form of the page shows the current name
+	form.Name = user.Name
+
+	matches, err := user.Password.Matches(form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(matches, "current_password", "Password is incorrect")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "new_password_confirmation", "Passwords do not match")
+
+	err = user.Password.Set(form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	user.Version = form.Version
+
+	models.ValidateUser(&form.Validator, user)
+	if !form.Valid() {
+		// The error for the new password is under the "password" key
+		if msg, ok := form.FieldErrors["password"]; ok {
+			form.AddFieldError("new_password", msg)
+		}
+
+		app.renderAccountPage(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.Models.Users.Update(user)
+	if err != nil {
+		if errors.Is(err, models.ErrEditConflict) {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in.
+	// The token of this session is renewed first, so that it is not destroyed with the others
+	err = app.SessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.destroyUserSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.Logger.Info("user password changed",
+		"requestId", requestId,
+		"userId", user.ID,
+	)
+
+	app.audit(r, models.AuditUserPassword, "user", user.ID, nil, nil)
+
+	app.SessionManager.Put(r.Context(), "flash", "Password changed successfully, you have been logged out everywhere else")
+
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}